@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/caoyingjunz/pixiulib/httputils"
@@ -13,8 +14,22 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/caoyingjunz/rainbow/cmd/app/options"
+	"github.com/caoyingjunz/rainbow/pkg/controller/rainbow"
 )
 
+// agent 专用接口的路由前缀，使用 agent token 认证
+const agentAPIPrefix = "/rainbow/agents/:Id/"
+
+// callbackTaskIdKey 使用回调 token 认证的请求只能更新该任务
+const callbackTaskIdKey = "callbackTaskId"
+
+// 插件回调的接口，除了 access key 之外也可以使用任务的回调 token 认证
+var callbackRoutes = map[string]bool{
+	"/rainbow/tasks/:Id/status": true,
+	"/rainbow/images/status":    true,
+	"/rainbow/images/progress":  true,
+}
+
 func NewMiddlewares(o *options.ServerOptions) {
 	o.HttpEngine.Use(
 		Authentication(o),
@@ -31,6 +46,25 @@ func Authentication(o *options.ServerOptions) gin.HandlerFunc {
 			return
 		}
 
+		// agent 的 token 和名称绑定，只能以路径中的 agent 名称调用
+		if strings.HasPrefix(c.FullPath(), agentAPIPrefix) {
+			token := rainbow.ParseAgentToken(c.GetHeader(rainbow.AgentTokenHeader))
+			if !rainbow.VerifyAgentToken(auth.AgentToken, c.Param("Id"), token) {
+				httputils.AbortFailedWithCode(c, http.StatusUnauthorized, fmt.Errorf("invalid Agent Token"))
+			}
+			return
+		}
+
+		if callbackRoutes[c.FullPath()] && len(c.GetHeader(rainbow.AgentTokenHeader)) != 0 {
+			taskId, ok := rainbow.ParseCallbackToken(auth.AgentToken, rainbow.ParseAgentToken(c.GetHeader(rainbow.AgentTokenHeader)))
+			if !ok {
+				httputils.AbortFailedWithCode(c, http.StatusUnauthorized, fmt.Errorf("invalid Callback Token"))
+				return
+			}
+			c.Set(callbackTaskIdKey, taskId)
+			return
+		}
+
 		accessKey := c.GetHeader("accessKey")
 		if accessKey != auth.AccessKey {
 			httputils.AbortFailedWithCode(c, http.StatusUnauthorized, fmt.Errorf("invalid Access Key"))
@@ -75,3 +109,15 @@ func verifySignature(accessKey, secretKey, signature, timestamp string) bool {
 	// 比较签名
 	return hmac.Equal([]byte(signature), []byte(expectedSignature))
 }
+
+// checkCallbackTask 使用回调 token 认证的请求只能更新 token 对应的任务
+func checkCallbackTask(c *gin.Context, taskId int64) error {
+	value, ok := c.Get(callbackTaskIdKey)
+	if !ok {
+		return nil
+	}
+	if value.(int64) != taskId {
+		return fmt.Errorf("callback token is not issued for task %d", taskId)
+	}
+	return nil
+}
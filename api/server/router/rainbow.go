@@ -29,6 +29,8 @@ func (cr *rainbowRouter) initRoutes(httpEngine *gin.Engine) {
 		taskRoute.GET("", cr.listTasks)
 
 		taskRoute.PUT("/:Id/status", cr.UpdateTaskStatus)
+		taskRoute.POST("/:Id/cancel", cr.cancelTask)
+		taskRoute.GET("/:Id/run", cr.getTaskRun)
 	}
 
 	registryRoute := httpEngine.Group("/rainbow/registries")
@@ -44,6 +46,16 @@ func (cr *rainbowRouter) initRoutes(httpEngine *gin.Engine) {
 	{
 		agentRoute.GET("/:Id", cr.getAgent)
 		agentRoute.GET("", cr.listAgents)
+
+		// agent 专用接口
+		agentRoute.POST("/:Id/register", cr.registerAgent)
+		agentRoute.PUT("/:Id/heartbeat", cr.agentHeartbeat)
+		agentRoute.PUT("/:Id/offline", cr.agentOffline)
+		agentRoute.GET("/:Id/tasks/watch", cr.watchAgentTasks)
+		agentRoute.POST("/:Id/tasks/claim", cr.claimTask)
+		agentRoute.GET("/:Id/tasks/config", cr.getPluginConfig)
+		agentRoute.PUT("/:Id/tasks/result", cr.reportTask)
+		agentRoute.GET("/:Id/runs", cr.listAgentRuns)
 	}
 
 	imageRoute := httpEngine.Group("/rainbow/images")
@@ -54,5 +66,7 @@ func (cr *rainbowRouter) initRoutes(httpEngine *gin.Engine) {
 		imageRoute.GET("", cr.listImages)
 
 		imageRoute.PUT("/status", cr.UpdateImageStatus)
+		imageRoute.PUT("/progress", cr.updateImageProgress)
+		imageRoute.POST("/extract", cr.extractImages)
 	}
 }
@@ -1,12 +1,17 @@
 package router
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 
 	"github.com/caoyingjunz/pixiulib/httputils"
 	"github.com/caoyingjunz/rainbow/pkg/types"
 )
 
+// 长轮询的最长等待时间
+const maxWatchTimeout = 60 * time.Second
+
 func (cr *rainbowRouter) createTask(c *gin.Context) {
 	resp := httputils.NewResponse()
 
@@ -63,6 +68,10 @@ func (cr *rainbowRouter) UpdateTaskStatus(c *gin.Context) {
 	}
 
 	req.TaskId = idMeta.ID
+	if err = checkCallbackTask(c, req.TaskId); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
 	if err = cr.c.Server().UpdateTaskStatus(c, &req); err != nil {
 		httputils.SetFailed(c, resp, err)
 		return
@@ -95,6 +104,44 @@ func (cr *rainbowRouter) listTasks(c *gin.Context) {
 	httputils.SetSuccess(c, resp)
 }
 
+func (cr *rainbowRouter) cancelTask(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		idMeta types.IdMeta
+		err    error
+	)
+	if err = httputils.ShouldBindAny(c, nil, &idMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if err = cr.c.Server().CancelTask(c, idMeta.ID); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) getTaskRun(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		idMeta types.IdMeta
+		err    error
+	)
+	if err = httputils.ShouldBindAny(c, nil, &idMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if resp.Result, err = cr.c.Server().GetTaskRun(c, idMeta.ID); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
 func (cr *rainbowRouter) createRegistry(c *gin.Context) {
 	resp := httputils.NewResponse()
 
@@ -219,6 +266,168 @@ func (cr *rainbowRouter) listAgents(c *gin.Context) {
 	httputils.SetSuccess(c, resp)
 }
 
+func (cr *rainbowRouter) watchAgentTasks(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		watchMeta types.WatchMeta
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, nil, &agentMeta, &watchMeta); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	timeout := time.Duration(watchMeta.Timeout) * time.Second
+	if timeout <= 0 || timeout > maxWatchTimeout {
+		timeout = maxWatchTimeout
+	}
+	if resp.Result, err = cr.c.Server().WatchAgentTasks(c.Request.Context(), agentMeta.Name, timeout); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) registerAgent(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		req       types.RegisterAgentRequest
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, &req, &agentMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if err = cr.c.Server().RegisterAgent(c, agentMeta.Name, &req); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) agentHeartbeat(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, nil, &agentMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if err = cr.c.Server().AgentHeartbeat(c, agentMeta.Name); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) agentOffline(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, nil, &agentMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if err = cr.c.Server().AgentOffline(c, agentMeta.Name); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) claimTask(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		req       types.ClaimTaskRequest
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, &req, &agentMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if resp.Result, err = cr.c.Server().ClaimTask(c, agentMeta.Name, &req); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) getPluginConfig(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		taskMeta  types.TaskIdMeta
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, nil, &agentMeta, &taskMeta); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if resp.Result, err = cr.c.Server().GetPluginConfig(c, agentMeta.Name, taskMeta.TaskId); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) reportTask(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		req       types.ReportTaskRequest
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, &req, &agentMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if err = cr.c.Server().ReportTask(c, agentMeta.Name, &req); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) listAgentRuns(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		agentMeta types.AgentNameMeta
+		err       error
+	)
+	if err = httputils.ShouldBindAny(c, nil, &agentMeta, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if resp.Result, err = cr.c.Server().ListAgentRuns(c, agentMeta.Name); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
 func (cr *rainbowRouter) createImage(c *gin.Context) {
 	resp := httputils.NewResponse()
 
@@ -273,6 +482,10 @@ func (cr *rainbowRouter) UpdateImageStatus(c *gin.Context) {
 		return
 	}
 
+	if err = checkCallbackTask(c, req.TaskId); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
 	if err = cr.c.Server().UpdateImageStatus(c, &req); err != nil {
 		httputils.SetFailed(c, resp, err)
 		return
@@ -281,6 +494,50 @@ func (cr *rainbowRouter) UpdateImageStatus(c *gin.Context) {
 	httputils.SetSuccess(c, resp)
 }
 
+func (cr *rainbowRouter) updateImageProgress(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		req types.UpdateImageProgressRequest
+		err error
+	)
+	if err = httputils.ShouldBindAny(c, &req, nil, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	if err = checkCallbackTask(c, req.TaskId); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+	if err = cr.c.Server().UpdateImageProgress(c, &req); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
+func (cr *rainbowRouter) extractImages(c *gin.Context) {
+	resp := httputils.NewResponse()
+
+	var (
+		req types.ExtractImagesRequest
+		err error
+	)
+	if err = httputils.ShouldBindAny(c, &req, nil, nil); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	if resp.Result, err = cr.c.Server().ExtractImages(c, &req); err != nil {
+		httputils.SetFailed(c, resp, err)
+		return
+	}
+
+	httputils.SetSuccess(c, resp)
+}
+
 func (cr *rainbowRouter) getImage(c *gin.Context) {
 	resp := httputils.NewResponse()
 
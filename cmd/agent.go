@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"flag"
+	"os/signal"
+	"syscall"
 
 	"k8s.io/klog/v2"
 
@@ -25,12 +27,12 @@ func main() {
 		klog.Fatal(err)
 	}
 
-	runers := []func(context.Context, int) error{opts.Controller.Agent().Run}
-	for _, runner := range runers {
-		if err = runner(context.TODO(), 5); err != nil {
-			klog.Fatal("failed to rainbow agent: ", err)
-		}
-	}
+	// 收到退出信号后取消 ctx，agent 等待处理中的任务完成后退出
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	select {}
+	if err = opts.Controller.Agent().Run(ctx, 5); err != nil {
+		klog.Fatal("failed to rainbow agent: ", err)
+	}
+	klog.Info("rainbow agent stopped")
 }
@@ -1,11 +1,21 @@
 package config
 
+import (
+	"time"
+
+	"github.com/caoyingjunz/rainbow/pkg/imagesource"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
 type Config struct {
 	Default DefaultOption `yaml:"default"`
 	Mysql   MysqlOptions  `yaml:"mysql"`
 
 	Kubernetes KubernetesOption `yaml:"kubernetes"`
 	Images     []string         `yaml:"images"`
+	// 从本地 helm chart 和 kubernetes manifest 文件或目录中提取镜像
+	Charts    []imagesource.Chart `yaml:"charts"`
+	Manifests []string            `yaml:"manifests"`
 
 	Server ServerOption `yaml:"server"`
 
@@ -21,25 +31,76 @@ type DefaultOption struct {
 
 	PushKubernetes bool `yaml:"push_kubernetes"`
 	PushImages     bool `yaml:"push_images"`
+
+	// 同步的镜像平台，例如 linux/amd64, linux/arm64，为空时同步所有平台
+	Platforms []string `yaml:"platforms"`
+	// 为 true 时目标仓库已存在相同镜像也重新同步
+	Force bool `yaml:"force"`
+	// 镜像中的 tag 匹配规则最多展开的 tag 数量，默认为 10
+	MaxTags int `yaml:"max_tags"`
 }
 
 type ServerOption struct {
-	Auth Auth `yaml:"auth"`
+	Auth      Auth            `yaml:"auth"`
+	Scheduler SchedulerOption `yaml:"scheduler"`
+}
+
+type SchedulerOption struct {
+	// 调度策略，支持 round-robin, least-loaded, affinity, spread，默认为 least-loaded
+	Policy string `yaml:"policy"`
+	// affinity 策略优先选择的 agent 标签
+	PreferredLabels map[string]string `yaml:"preferred_labels"`
+	// spread 策略按照该标签的取值打散任务
+	SpreadLabel string `yaml:"spread_label"`
+	// agent 失联后任务最多被重新调度的次数，超过后任务置为失败，未配置时为 3，0 表示不重新调度，小于 0 表示不限制
+	MaxReassignments *int `yaml:"max_reassignments"`
 }
 
 type Auth struct {
 	AccessKey string `yaml:"access_key"`
 	SecretKey string `yaml:"secret_key"`
+	// 签发 agent token 和插件回调 token 的密钥，agent 的 token 为 HMAC-SHA256(agent_token, "agent/<agent 名称>")，
+	// 通过 server 的 -agentToken 参数生成
+	AgentToken string `yaml:"agent_token"`
 }
 
 type KubernetesOption struct {
 	Version string `yaml:"version"`
+	// 镜像解析方式，builtin 使用内置的版本表，kubeadm 使用节点上已安装的 kubeadm，
+	// 为空时优先使用内置的版本表，版本表中没有的版本使用 kubeadm
+	Resolver string `yaml:"resolver"`
+	// 控制面镜像的仓库，默认为 registry.k8s.io
+	ImageRepository string `yaml:"image_repository"`
+	// 覆盖内置版本表的文件，用于支持新发布的版本
+	VersionTable string `yaml:"version_table"`
 }
 
 type PluginOption struct {
 	Callback string `yaml:"callback"`
-	TaskId   int64  `yaml:"task_id"`
-	Synced   bool   `yaml:"synced"`
+	// 回调 server 时使用的 token，由 server 为每个任务生成
+	CallbackToken string `yaml:"callback_token"`
+	TaskId        int64  `yaml:"task_id"`
+	Synced        bool   `yaml:"synced"`
+	// 镜像同步方式，支持 registry, docker，默认为 registry
+	Driver string `yaml:"driver"`
+
+	// 同时同步的镜像数量，默认为 5
+	Concurrency int `yaml:"concurrency"`
+	// 镜像遇到限流，服务端错误等临时错误时的最大重试次数，默认为 3，小于 0 时不重试
+	MaxRetries int `yaml:"max_retries"`
+	// 首次重试的等待时间，之后每次翻倍，默认为 2s
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// 单个镜像包括重试在内的最长同步时间，默认为 30m
+	ImageTimeout time.Duration `yaml:"image_timeout"`
+	// 上报镜像同步进度的间隔，默认为 3s
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+
+	// 按顺序执行的步骤，为空时为 preflight, login, resolve, copy, verify, report, cleanup
+	Stages []string `yaml:"stages"`
+	// 每个镜像同步前后执行的钩子，需要先在插件中注册
+	ImageHooks []string `yaml:"image_hooks"`
+	// export 步骤写入的离线包路径，也是 import 命令默认读取的离线包
+	Bundle string `yaml:"bundle"`
 }
 
 type Registry struct {
@@ -47,6 +108,18 @@ type Registry struct {
 	Namespace  string `yaml:"namespace"`
 	Username   string `yaml:"username"`
 	Password   string `yaml:"password"`
+	// 从文件中读取密码，例如挂载的 kubernetes secret，不能和 password 同时配置
+	PasswordFile string `yaml:"password_file"`
+	// 直接作为 Bearer token 访问仓库
+	Token string `yaml:"token"`
+	// 通过 OAuth2 refresh_token 方式获取访问 token
+	IdentityToken string `yaml:"identity_token"`
+	// docker credential helper 的名称，例如 ecr-login 会执行 docker-credential-ecr-login get
+	CredentialHelper string `yaml:"credential_helper"`
+	// 使用 http 访问仓库
+	Insecure bool `yaml:"insecure"`
+	// 源镜像到目标镜像的命名规则
+	Naming registry.NamingPolicy `yaml:"naming"`
 }
 
 type MysqlOptions struct {
@@ -60,4 +133,43 @@ type MysqlOptions struct {
 type AgentOption struct {
 	Name    string `yaml:"name"`
 	DataDir string `yaml:"data_dir"`
+
+	// server 的地址，agent 通过 server 的接口注册和获取任务，未配置时使用插件回调地址
+	Server string `yaml:"server"`
+	Token  string `yaml:"token"`
+
+	// 自定义的调度标签，不能使用内置的 arch 标签
+	Labels map[string]string `yaml:"labels"`
+	// 作为内置的 arch 标签，未配置时为 agent 的运行架构
+	Arch               string `yaml:"arch"`
+	MaxConcurrentTasks int    `yaml:"max_concurrent_tasks"`
+
+	// 任务同步失败后的最大重试次数，超过后任务置为失败
+	MaxRetries int `yaml:"max_retries"`
+
+	// 插件的执行方式，支持 git, local, process, kubernetes，默认为 git
+	Mode string `yaml:"mode"`
+	// process 模式下插件二进制的路径
+	PluginBinary string `yaml:"plugin_binary"`
+	// kubernetes 模式下 Job 的配置
+	Job JobOption `yaml:"job"`
+
+	Workspace WorkspaceOption `yaml:"workspace"`
+}
+
+type WorkspaceOption struct {
+	// 任务目录的保留时间，默认为 24h
+	TTL time.Duration `yaml:"ttl"`
+	// DataDir 下工作目录的占用上限，默认为 10240 MB
+	MaxSizeMB int64 `yaml:"max_size_mb"`
+}
+
+type JobOption struct {
+	// 为空时使用 in-cluster 配置
+	Kubeconfig string `yaml:"kubeconfig"`
+	Namespace  string `yaml:"namespace"`
+	// 插件镜像，kubernetes 模式下必须指定
+	Image string `yaml:"image"`
+	// 结束的 Job 及其 Pod 和 Secret 的保留时间，默认为 1h
+	TTLAfterFinished time.Duration `yaml:"ttl_after_finished"`
 }
@@ -3,22 +3,27 @@ package options
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/caoyingjunz/pixiulib/config"
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 	"k8s.io/klog/v2"
 
 	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
 	"github.com/caoyingjunz/rainbow/pkg/controller"
-	rainbowdb "github.com/caoyingjunz/rainbow/pkg/db"
+	"github.com/caoyingjunz/rainbow/pkg/controller/dispatcher"
 )
 
 const (
 	defaultConfigFile = "/etc/rainbow/config.yaml"
 	defaultDataDir    = "/data"
 	defaultListen     = 8090
+	defaultMaxRetries = 5
+
+	defaultPluginBinary = "rainbow-plugin"
+
+	defaultWorkspaceTTL       = 24 * time.Hour
+	defaultWorkspaceMaxSizeMB = 10240
 
 	maxIdleConns = 10
 	maxOpenConns = 100
@@ -28,9 +33,6 @@ type Options struct {
 	ComponentConfig rainbowconfig.Config
 	ConfigFile      string
 
-	db      *gorm.DB
-	Factory rainbowdb.ShareDaoFactory
-
 	HttpEngine *gin.Engine
 	Controller controller.RainbowInterface
 }
@@ -65,42 +67,38 @@ func (o *Options) Complete() error {
 	if len(o.ComponentConfig.Agent.DataDir) == 0 {
 		o.ComponentConfig.Agent.DataDir = defaultDataDir
 	}
+	if o.ComponentConfig.Agent.MaxRetries == 0 {
+		o.ComponentConfig.Agent.MaxRetries = defaultMaxRetries
+	}
+	switch o.ComponentConfig.Agent.Mode {
+	case "", dispatcher.GitMode, dispatcher.LocalMode, dispatcher.ProcessMode, dispatcher.KubernetesMode:
+	default:
+		return fmt.Errorf("unsupported agent mode %s", o.ComponentConfig.Agent.Mode)
+	}
+	if len(o.ComponentConfig.Agent.PluginBinary) == 0 {
+		o.ComponentConfig.Agent.PluginBinary = defaultPluginBinary
+	}
+	if o.ComponentConfig.Agent.Workspace.TTL == 0 {
+		o.ComponentConfig.Agent.Workspace.TTL = defaultWorkspaceTTL
+	}
+	if o.ComponentConfig.Agent.Workspace.MaxSizeMB == 0 {
+		o.ComponentConfig.Agent.Workspace.MaxSizeMB = defaultWorkspaceMaxSizeMB
+	}
 	if o.ComponentConfig.Default.Listen == 0 {
 		o.ComponentConfig.Default.Listen = defaultListen
 	}
 
-	// 注册依赖组件
-	if err := o.register(); err != nil {
-		return err
+	// agent 只通过 server 的 API 获取任务，兼容仅配置了插件回调地址的旧配置
+	if len(o.ComponentConfig.Agent.Server) == 0 {
+		o.ComponentConfig.Agent.Server = o.ComponentConfig.Plugin.Callback
 	}
-
-	o.Controller = controller.New(o.ComponentConfig, o.Factory)
-	return nil
-}
-
-func (o *Options) register() error {
-	sqlConfig := o.ComponentConfig.Mysql
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local",
-		sqlConfig.User,
-		sqlConfig.Password,
-		sqlConfig.Host,
-		sqlConfig.Port,
-		sqlConfig.Name)
-
-	opt := &gorm.Config{}
-	db, err := gorm.Open(mysql.Open(dsn), opt)
-	if err != nil {
-		return err
+	if len(o.ComponentConfig.Agent.Server) == 0 {
+		return fmt.Errorf("agent server address missing")
 	}
-	o.db = db
-
-	sqlDB, err := db.DB()
-	if err != nil {
-		return err
+	if len(o.ComponentConfig.Plugin.Callback) == 0 {
+		o.ComponentConfig.Plugin.Callback = o.ComponentConfig.Agent.Server
 	}
-	sqlDB.SetMaxIdleConns(maxIdleConns)
-	sqlDB.SetMaxOpenConns(maxOpenConns)
 
-	o.Factory, err = rainbowdb.NewDaoFactory(db, true)
-	return err
+	o.Controller = controller.New(o.ComponentConfig, nil)
+	return nil
 }
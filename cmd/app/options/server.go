@@ -12,9 +12,14 @@ import (
 
 	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
 	"github.com/caoyingjunz/rainbow/pkg/controller"
+	"github.com/caoyingjunz/rainbow/pkg/controller/rainbow"
 	rainbowdb "github.com/caoyingjunz/rainbow/pkg/db"
 )
 
+const (
+	defaultMaxReassignments = 3
+)
+
 type ServerOptions struct {
 	ComponentConfig rainbowconfig.Config
 	ConfigFile      string
@@ -45,14 +50,19 @@ func (o *ServerOptions) Complete() error {
 		}
 	}
 
-	c := config.New()
-	c.SetConfigFile(o.ConfigFile)
-	c.SetConfigType("yaml")
-
-	if err := c.Binding(&o.ComponentConfig); err != nil {
+	if err := o.LoadConfig(); err != nil {
 		klog.Fatal(err)
 	}
 
+	// 显式配置的 0 表示不重新调度，只有未配置时使用默认值
+	if o.ComponentConfig.Server.Scheduler.MaxReassignments == nil {
+		maxReassignments := defaultMaxReassignments
+		o.ComponentConfig.Server.Scheduler.MaxReassignments = &maxReassignments
+	}
+	if _, err := rainbow.NewSchedulePolicy(o.ComponentConfig.Server.Scheduler); err != nil {
+		return err
+	}
+
 	// 注册依赖组件
 	if err := o.register(); err != nil {
 		return err
@@ -62,6 +72,15 @@ func (o *ServerOptions) Complete() error {
 	return nil
 }
 
+// LoadConfig 只读取配置文件，不连接数据库
+func (o *ServerOptions) LoadConfig() error {
+	c := config.New()
+	c.SetConfigFile(o.ConfigFile)
+	c.SetConfigType("yaml")
+
+	return c.Binding(&o.ComponentConfig)
+}
+
 func (o *ServerOptions) register() error {
 	sqlConfig := o.ComponentConfig.Mysql
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local",
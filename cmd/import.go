@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/caoyingjunz/pixiulib/config"
+	"k8s.io/klog/v2"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/controller/plugin"
+)
+
+var (
+	importFile = flag.String("configFile", "./config.yaml", "config file")
+	bundleFile = flag.String("bundle", "", "bundle file, default to plugin.bundle in config file")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	c := config.New()
+	c.SetConfigFile(*importFile)
+	c.SetConfigType("yaml")
+
+	var cfg rainbowconfig.Config
+	if err := c.Binding(&cfg); err != nil {
+		klog.Fatal(err)
+	}
+
+	bundle := *bundleFile
+	if len(bundle) == 0 {
+		bundle = cfg.Plugin.Bundle
+	}
+	if len(bundle) == 0 {
+		klog.Fatal("bundle file is required")
+	}
+
+	result, err := plugin.ImportBundle(cfg, bundle)
+	if err != nil {
+		klog.Errorf("failed to import bundle %s: %v", bundle, err)
+		klog.Flush()
+		os.Exit(plugin.ExitFailed)
+	}
+
+	// 退出码和插件一致，部分镜像失败时为 2
+	klog.Infof("import %s: %s", result.Status(), result)
+	klog.Flush()
+	os.Exit(result.ExitCode())
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"os"
 
 	"github.com/caoyingjunz/pixiulib/config"
 	"k8s.io/klog/v2"
@@ -31,9 +32,17 @@ func main() {
 	if err := pc.Complete(); err != nil {
 		klog.Fatal(err)
 	}
-	defer pc.Close()
 
-	if err := pc.Run(); err != nil {
-		klog.Fatal(err)
+	result, err := pc.Run()
+	pc.Close()
+	if err != nil {
+		klog.Errorf("failed to run plugin: %v", err)
+		klog.Flush()
+		os.Exit(plugin.ExitFailed)
 	}
+
+	// 退出码和任务的最终状态一致，部分镜像失败时为 2
+	klog.Infof("task %s: %s", result.Status(), result)
+	klog.Flush()
+	os.Exit(result.ExitCode())
 }
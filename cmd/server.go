@@ -14,10 +14,12 @@ import (
 
 	"github.com/caoyingjunz/rainbow/api/server/router"
 	"github.com/caoyingjunz/rainbow/cmd/app/options"
+	"github.com/caoyingjunz/rainbow/pkg/controller/rainbow"
 )
 
 var (
 	serverFilePath = flag.String("configFile", "./config.yaml", "config file")
+	agentName      = flag.String("agentToken", "", "print the token of the given agent and exit")
 )
 
 func main() {
@@ -28,6 +30,18 @@ func main() {
 	if err != nil {
 		klog.Fatal(err)
 	}
+	// 生成 agent 配置中的 token
+	if len(*agentName) != 0 {
+		if err = opts.LoadConfig(); err != nil {
+			klog.Fatal(err)
+		}
+		secret := opts.ComponentConfig.Server.Auth.AgentToken
+		if len(secret) == 0 {
+			klog.Fatal("server.auth.agent_token is not configured")
+		}
+		fmt.Println(rainbow.AgentToken(secret, *agentName))
+		return
+	}
 	if err = opts.Complete(); err != nil {
 		klog.Fatal(err)
 	}
@@ -35,9 +49,12 @@ func main() {
 	// 安装 http 路由
 	router.InstallRouters(opts)
 
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+
 	runers := []func(context.Context, int) error{opts.Controller.Server().Run}
 	for _, runner := range runers {
-		if err = runner(context.TODO(), 5); err != nil {
+		if err = runner(runCtx, 5); err != nil {
 			klog.Fatal("failed to rainbow agent: ", err)
 		}
 	}
@@ -53,11 +70,14 @@ func main() {
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	klog.Info("shutting rainbow server down ...")
 
+	// 停止调度和监控
+	stop()
+
 	// The context is used to inform the server it has 5 seconds to finish the request
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
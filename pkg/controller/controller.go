@@ -12,21 +12,23 @@ type RainbowInterface interface {
 }
 
 type rain struct {
-	factory db.ShareDaoFactory
-	cfg     rainbowconfig.Config
+	factory  db.ShareDaoFactory
+	cfg      rainbowconfig.Config
+	notifier *rainbow.Notifier
 }
 
 func (p *rain) Agent() rainbow.Interface {
-	return rainbow.NewAgent(p.factory, p.cfg)
+	return rainbow.NewAgent(rainbow.NewAgentClient(p.cfg.Agent.Server, p.cfg.Agent.Token), p.cfg)
 }
 
 func (p *rain) Server() rainbow.ServerInterface {
-	return rainbow.NewServer(p.factory)
+	return rainbow.NewServer(p.factory, p.cfg, p.notifier)
 }
 
 func New(cfg rainbowconfig.Config, f db.ShareDaoFactory) RainbowInterface {
 	return &rain{
-		factory: f,
-		cfg:     cfg,
+		factory:  f,
+		cfg:      cfg,
+		notifier: rainbow.NewNotifier(),
 	}
 }
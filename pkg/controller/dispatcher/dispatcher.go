@@ -0,0 +1,85 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/controller/plugin"
+	"github.com/caoyingjunz/rainbow/pkg/controller/workspace"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+)
+
+const (
+	// GitMode 推送任务分支，由外部 CI 执行插件，默认模式
+	GitMode = "git"
+	// LocalMode 在 agent 进程内执行插件
+	LocalMode = "local"
+	// ProcessMode 以子进程的方式执行插件
+	ProcessMode = "process"
+	// KubernetesMode 以 Kubernetes Job 的方式执行插件
+	KubernetesMode = "kubernetes"
+)
+
+const (
+	DispatchedStatus = "dispatched"
+	RunningStatus    = "running"
+	SucceededStatus  = "succeeded"
+	FailedStatus     = "failed"
+	UnknownStatus    = "unknown"
+	CanceledStatus   = "canceled"
+	// PartialFailedStatus 插件以 plugin.ExitPartialFailed 退出，部分镜像同步失败
+	PartialFailedStatus = "partial_failed"
+)
+
+// Dispatcher 负责为任务启动一次插件运行，不同的后端通过 Dispatch 返回的句柄查询状态，取消运行和获取日志
+type Dispatcher interface {
+	Name() string
+
+	// Dispatch 根据插件配置启动一次运行，返回本次运行的句柄
+	Dispatch(ctx context.Context, task model.Task, cfg []byte) (string, error)
+	// Wait 等待本地运行结束，由外部执行的后端直接返回
+	Wait(ctx context.Context, handle string) error
+
+	// Status, Cancel 和 Logs 由 agent 定期调用，结果通过 server 的任务运行接口查询
+	Status(ctx context.Context, handle string) (string, error)
+	Cancel(ctx context.Context, handle string) error
+	Logs(ctx context.Context, handle string) (string, error)
+}
+
+func New(opt rainbowconfig.AgentOption, ws *workspace.Manager) (Dispatcher, error) {
+	switch opt.Mode {
+	case GitMode, "":
+		return NewGitDispatcher(ws), nil
+	case LocalMode:
+		return NewLocalDispatcher(ws, "", true), nil
+	case ProcessMode:
+		return NewLocalDispatcher(ws, opt.PluginBinary, false), nil
+	case KubernetesMode:
+		if len(opt.Job.Image) == 0 {
+			return nil, fmt.Errorf("plugin image is required by %s dispatcher", KubernetesMode)
+		}
+		client, err := newKubernetesClient(opt.Job.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		return NewJobDispatcher(client, opt.Job), nil
+	}
+
+	return nil, fmt.Errorf("unsupported agent mode %s", opt.Mode)
+}
+
+func notSupported(dispatcher string, action string) error {
+	return fmt.Errorf("%s is not supported by %s dispatcher", action, dispatcher)
+}
+
+// exitStatus 根据插件的退出码返回运行状态
+func exitStatus(code int) string {
+	switch code {
+	case plugin.ExitSucceeded:
+		return SucceededStatus
+	case plugin.ExitPartialFailed:
+		return PartialFailedStatus
+	}
+	return FailedStatus
+}
@@ -0,0 +1,78 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/caoyingjunz/rainbow/pkg/controller/workspace"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/util"
+)
+
+// gitDispatcher 将插件配置推送到任务分支，由外部 CI 执行插件，句柄为任务分支名
+// 所有任务共用工作目录中的同一份插件仓库，推送完成后删除本地的任务分支
+type gitDispatcher struct {
+	workspace *workspace.Manager
+}
+
+func NewGitDispatcher(ws *workspace.Manager) Dispatcher {
+	return &gitDispatcher{workspace: ws}
+}
+
+func (g *gitDispatcher) Name() string { return GitMode }
+
+func (g *gitDispatcher) Dispatch(ctx context.Context, task model.Task, cfg []byte) (string, error) {
+	taskIdStr := fmt.Sprintf("%d", task.Id)
+
+	err := g.workspace.WithRepo(func(repoDir string) error {
+		if !util.IsDirectoryExists(repoDir) {
+			return fmt.Errorf("plugin repository %s not found", repoDir)
+		}
+
+		git := util.NewGit(repoDir, taskIdStr, taskIdStr+"-"+time.Now().String())
+		if err := git.Fetch(); err != nil {
+			return err
+		}
+		if err := git.Checkout(); err != nil {
+			return err
+		}
+		// 无论推送是否成功，都回到远端 master 并删除本地的任务分支
+		defer func() {
+			if err := git.Detach(); err != nil {
+				klog.Errorf("failed to detach plugin repository: %v", err)
+				return
+			}
+			if err := git.DeleteBranch(taskIdStr); err != nil {
+				klog.Errorf("failed to delete branch %s: %v", taskIdStr, err)
+			}
+		}()
+
+		if err := util.WriteIntoFile(string(cfg), filepath.Join(repoDir, "config.yaml")); err != nil {
+			return err
+		}
+		return git.Push()
+	})
+	if err != nil {
+		return "", err
+	}
+	return taskIdStr, nil
+}
+
+func (g *gitDispatcher) Wait(ctx context.Context, handle string) error { return nil }
+
+// Status 插件由外部 CI 执行，运行状态只能通过插件的回调获取
+func (g *gitDispatcher) Status(ctx context.Context, handle string) (string, error) {
+	return DispatchedStatus, nil
+}
+
+func (g *gitDispatcher) Cancel(ctx context.Context, handle string) error {
+	return notSupported(GitMode, "cancel")
+}
+
+func (g *gitDispatcher) Logs(ctx context.Context, handle string) (string, error) {
+	return "", notSupported(GitMode, "logs")
+}
@@ -0,0 +1,282 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/controller/plugin"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+)
+
+const (
+	defaultJobNamespace = "default"
+	// 结束的 Job 保留一段时间，agent 在此期间同步运行的最终状态和日志
+	defaultJobTTLAfterFinished = 1 * time.Hour
+
+	pluginContainer  = "plugin"
+	pluginConfigDir  = "/etc/rainbow"
+	pluginConfigFile = "config.yaml"
+	dockerSocket     = "/var/run/docker.sock"
+
+	taskIdLabel = "rainbow.pixiu.io/task-id"
+)
+
+// jobDispatcher 以 Kubernetes Job 的方式执行插件，句柄为 <namespace>/<name>
+type jobDispatcher struct {
+	client    kubernetes.Interface
+	namespace string
+	image     string
+	// Job 结束后由 kubernetes 删除，Pod 和 Secret 随 Job 一起被回收
+	ttlAfterFinished time.Duration
+}
+
+func newKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	// kubeconfig 为空时使用 in-cluster 配置
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubernetes config %v", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func NewJobDispatcher(client kubernetes.Interface, opt rainbowconfig.JobOption) Dispatcher {
+	j := &jobDispatcher{
+		client:    client,
+		namespace: opt.Namespace,
+		image:     opt.Image,
+
+		ttlAfterFinished: opt.TTLAfterFinished,
+	}
+	if len(j.namespace) == 0 {
+		j.namespace = defaultJobNamespace
+	}
+	if j.ttlAfterFinished <= 0 {
+		j.ttlAfterFinished = defaultJobTTLAfterFinished
+	}
+	return j
+}
+
+func (j *jobDispatcher) Name() string { return KubernetesMode }
+
+// Dispatch 先创建保存插件配置的 Secret，再创建挂载它的 Job，最后将 Secret 的 owner 设置为 Job，使其随 Job 一起被回收。
+// 任务同步重试时 Job 可能已经创建，属于同一任务的 Job 直接沿用
+func (j *jobDispatcher) Dispatch(ctx context.Context, task model.Task, cfg []byte) (string, error) {
+	name := fmt.Sprintf("rainbow-task-%d-%d", task.Id, task.ResourceVersion)
+	taskId := fmt.Sprintf("%d", task.Id)
+	labels := map[string]string{
+		"app":       "rainbow-plugin",
+		taskIdLabel: taskId,
+	}
+
+	var pluginCfg rainbowconfig.Config
+	if err := yaml.Unmarshal(cfg, &pluginCfg); err != nil {
+		return "", fmt.Errorf("failed to parse plugin config %v", err)
+	}
+
+	// 插件配置中包含仓库的认证信息，使用 Secret 保存
+	secret, err := j.applySecret(ctx, name, taskId, labels, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	job, err := j.client.BatchV1().Jobs(j.namespace).Create(ctx, j.newJob(name, labels, pluginCfg.Plugin.Driver), metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		if job, err = j.client.BatchV1().Jobs(j.namespace).Get(ctx, name, metav1.GetOptions{}); err == nil && job.Labels[taskIdLabel] != taskId {
+			err = fmt.Errorf("job %s belongs to another task", name)
+		}
+	}
+	if err != nil {
+		if len(secret.OwnerReferences) == 0 {
+			_ = j.client.CoreV1().Secrets(j.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		}
+		return "", fmt.Errorf("failed to create job %s: %v", name, err)
+	}
+
+	if len(secret.OwnerReferences) == 0 {
+		secret.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(job, batchv1.SchemeGroupVersion.WithKind("Job")),
+		}
+		if _, err = j.client.CoreV1().Secrets(j.namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return "", fmt.Errorf("failed to set owner of secret %s: %v", name, err)
+		}
+	}
+
+	return j.namespace + "/" + name, nil
+}
+
+// applySecret 创建或者更新保存插件配置的 Secret，已存在的 Secret 必须属于同一任务
+func (j *jobDispatcher) applySecret(ctx context.Context, name string, taskId string, labels map[string]string, cfg []byte) (*corev1.Secret, error) {
+	secret, err := j.client.CoreV1().Secrets(j.namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: j.namespace, Labels: labels},
+		Data:       map[string][]byte{pluginConfigFile: cfg},
+	}, metav1.CreateOptions{})
+	if err == nil {
+		return secret, nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create secret %s: %v", name, err)
+	}
+
+	if secret, err = j.client.CoreV1().Secrets(j.namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		return nil, err
+	}
+	if secret.Labels[taskIdLabel] != taskId {
+		return nil, fmt.Errorf("secret %s belongs to another task", name)
+	}
+	secret.Data = map[string][]byte{pluginConfigFile: cfg}
+	if secret, err = j.client.CoreV1().Secrets(j.namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to update secret %s: %v", name, err)
+	}
+	return secret, nil
+}
+
+// newJob 只有 docker 驱动需要挂载节点的 docker.sock，其他驱动不需要访问节点
+func (j *jobDispatcher) newJob(name string, labels map[string]string, driver string) *batchv1.Job {
+	container := corev1.Container{
+		Name:         pluginContainer,
+		Image:        j.image,
+		Args:         []string{"--configFile", pluginConfigDir + "/" + pluginConfigFile},
+		VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: pluginConfigDir}},
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: name},
+			},
+		},
+	}
+	if driver == plugin.DockerDriver {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "docker", MountPath: dockerSocket})
+		volumes = append(volumes, corev1.Volume{
+			Name: "docker",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: dockerSocket},
+			},
+		})
+	}
+
+	var backoffLimit int32 = 0
+	ttl := int32(j.ttlAfterFinished.Seconds())
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: j.namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{container},
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+}
+
+// Wait Job 在集群中运行，结束状态由 agent 定期通过 Status 同步，这里直接返回
+func (j *jobDispatcher) Wait(ctx context.Context, handle string) error { return nil }
+
+func (j *jobDispatcher) Status(ctx context.Context, handle string) (string, error) {
+	namespace, name, err := parseJobHandle(handle)
+	if err != nil {
+		return "", err
+	}
+	job, err := j.client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		return SucceededStatus, nil
+	case job.Status.Failed > 0:
+		// 部分镜像同步失败时插件同样以非 0 退出，按照容器的退出码区分
+		return j.failedStatus(ctx, namespace, name), nil
+	case job.Status.Active > 0:
+		return RunningStatus, nil
+	}
+	return DispatchedStatus, nil
+}
+
+// failedStatus 无法获取插件容器的退出码时返回 FailedStatus
+func (j *jobDispatcher) failedStatus(ctx context.Context, namespace, name string) string {
+	pod, err := j.latestPod(ctx, namespace, name)
+	if err != nil {
+		return FailedStatus
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == pluginContainer && status.State.Terminated != nil {
+			return exitStatus(int(status.State.Terminated.ExitCode))
+		}
+	}
+	return FailedStatus
+}
+
+func (j *jobDispatcher) Cancel(ctx context.Context, handle string) error {
+	namespace, name, err := parseJobHandle(handle)
+	if err != nil {
+		return err
+	}
+	return j.deleteJob(ctx, namespace, name)
+}
+
+func (j *jobDispatcher) deleteJob(ctx context.Context, namespace, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	return j.client.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+func (j *jobDispatcher) Logs(ctx context.Context, handle string) (string, error) {
+	namespace, name, err := parseJobHandle(handle)
+	if err != nil {
+		return "", err
+	}
+	pod, err := j.latestPod(ctx, namespace, name)
+	if err != nil {
+		return "", err
+	}
+	data, err := j.client.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// latestPod 返回 Job 最新创建的 pod
+func (j *jobDispatcher) latestPod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	pods, err := j.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + name})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no pod found for job %s/%s", namespace, name)
+	}
+
+	pod := pods.Items[0]
+	for _, p := range pods.Items[1:] {
+		if p.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			pod = p
+		}
+	}
+	return &pod, nil
+}
+
+func parseJobHandle(handle string) (string, string, error) {
+	parts := strings.Split(handle, "/")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid job handle %s", handle)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,281 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+)
+
+const testPluginConfig = `plugin:
+  task_id: 1
+  driver: %s
+registry:
+  repository: harbor.example.com
+  password: secret
+`
+
+func newTestJobDispatcher() (*jobDispatcher, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	d := NewJobDispatcher(client, rainbowconfig.JobOption{Namespace: "rainbow", Image: "rainbow/plugin:test"})
+	return d.(*jobDispatcher), client
+}
+
+func testTask() model.Task {
+	task := model.Task{}
+	task.Id, task.ResourceVersion = 1, 2
+	return task
+}
+
+func hasVolume(job *batchv1.Job, name string) bool {
+	for _, v := range job.Spec.Template.Spec.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJobDispatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		driver     string
+		wantDocker bool
+	}{
+		{name: "registry driver", driver: "registry"},
+		{name: "default driver", driver: `""`},
+		{name: "docker driver", driver: "docker", wantDocker: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d, client := newTestJobDispatcher()
+			ctx := context.Background()
+			cfg := []byte(fmtConfig(tc.driver))
+
+			handle, err := d.Dispatch(ctx, testTask(), cfg)
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			if handle != "rainbow/rainbow-task-1-2" {
+				t.Fatalf("Dispatch() handle = %s", handle)
+			}
+
+			job, err := client.BatchV1().Jobs("rainbow").Get(ctx, "rainbow-task-1-2", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("job not created: %v", err)
+			}
+			if job.Labels[taskIdLabel] != "1" {
+				t.Errorf("job task label = %q", job.Labels[taskIdLabel])
+			}
+			if job.Spec.Template.Spec.Containers[0].Image != "rainbow/plugin:test" {
+				t.Errorf("job image = %s", job.Spec.Template.Spec.Containers[0].Image)
+			}
+			// 结束的 Job 由 kubernetes 回收，Secret 通过 owner 随 Job 一起删除
+			if ttl := job.Spec.TTLSecondsAfterFinished; ttl == nil || *ttl != int32(defaultJobTTLAfterFinished.Seconds()) {
+				t.Errorf("job ttlSecondsAfterFinished = %v", ttl)
+			}
+			if got := hasVolume(job, "docker"); got != tc.wantDocker {
+				t.Errorf("docker.sock mounted = %v, want %v", got, tc.wantDocker)
+			}
+
+			secret, err := client.CoreV1().Secrets("rainbow").Get(ctx, "rainbow-task-1-2", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("secret not created: %v", err)
+			}
+			if string(secret.Data[pluginConfigFile]) != string(cfg) {
+				t.Errorf("secret data = %q", secret.Data[pluginConfigFile])
+			}
+			if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Kind != "Job" || secret.OwnerReferences[0].Name != job.Name {
+				t.Errorf("secret owner = %+v", secret.OwnerReferences)
+			}
+			if _, err = client.CoreV1().ConfigMaps("rainbow").Get(ctx, "rainbow-task-1-2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("plugin config must not be stored in a configmap, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJobDispatchAlreadyExists(t *testing.T) {
+	d, client := newTestJobDispatcher()
+	ctx := context.Background()
+	cfg := []byte(fmtConfig("registry"))
+
+	first, err := d.Dispatch(ctx, testTask(), cfg)
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	// 任务同步重试时沿用已创建的 Job
+	second, err := d.Dispatch(ctx, testTask(), cfg)
+	if err != nil {
+		t.Fatalf("retried Dispatch() error = %v", err)
+	}
+	if first != second {
+		t.Errorf("retried Dispatch() handle = %s, want %s", second, first)
+	}
+	jobs, _ := client.BatchV1().Jobs("rainbow").List(ctx, metav1.ListOptions{})
+	if len(jobs.Items) != 1 {
+		t.Errorf("jobs = %d, want 1", len(jobs.Items))
+	}
+
+	// 同名的 Job 属于其他任务时不能沿用
+	other, _ := client.BatchV1().Jobs("rainbow").Get(ctx, "rainbow-task-1-2", metav1.GetOptions{})
+	other.Labels[taskIdLabel] = "9"
+	if _, err = client.BatchV1().Jobs("rainbow").Update(ctx, other, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Dispatch(ctx, testTask(), cfg); err == nil {
+		t.Errorf("Dispatch() adopted a job of another task")
+	}
+}
+
+func TestJobDispatchJobFailed(t *testing.T) {
+	d, client := newTestJobDispatcher()
+	ctx := context.Background()
+	client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("quota exceeded")
+	})
+
+	if _, err := d.Dispatch(ctx, testTask(), []byte(fmtConfig("registry"))); err == nil {
+		t.Fatalf("Dispatch() should fail when the job can not be created")
+	}
+	// Job 创建失败时删除已创建的 Secret，不留下半分发的任务
+	if _, err := client.CoreV1().Secrets("rainbow").Get(ctx, "rainbow-task-1-2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("secret not deleted after job creation failed, got %v", err)
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status batchv1.JobStatus
+		// 插件容器的退出码，为空时不创建 pod
+		exitCode *int32
+		want     string
+	}{
+		{name: "pending", want: DispatchedStatus},
+		{name: "running", status: batchv1.JobStatus{Active: 1}, want: RunningStatus},
+		{name: "succeeded", status: batchv1.JobStatus{Succeeded: 1}, want: SucceededStatus},
+		{name: "failed", status: batchv1.JobStatus{Failed: 1}, want: FailedStatus},
+		{name: "failed with exit code", status: batchv1.JobStatus{Failed: 1}, exitCode: int32Ptr(1), want: FailedStatus},
+		{name: "partial failed", status: batchv1.JobStatus{Failed: 1}, exitCode: int32Ptr(2), want: PartialFailedStatus},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d, client := newTestJobDispatcher()
+			ctx := context.Background()
+
+			handle, err := d.Dispatch(ctx, testTask(), []byte(fmtConfig("registry")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			job, _ := client.BatchV1().Jobs("rainbow").Get(ctx, "rainbow-task-1-2", metav1.GetOptions{})
+			job.Status = tc.status
+			if _, err = client.BatchV1().Jobs("rainbow").UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if tc.exitCode != nil {
+				if _, err = client.CoreV1().Pods("rainbow").Create(ctx, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "rainbow-task-1-2-abcde", Namespace: "rainbow", Labels: map[string]string{"job-name": "rainbow-task-1-2"}},
+					Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+						Name:  pluginContainer,
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: *tc.exitCode}},
+					}}},
+				}, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Job 的结束状态通过 Status 同步，Wait 不阻塞
+			if err = d.Wait(ctx, handle); err != nil {
+				t.Errorf("Wait() error = %v", err)
+			}
+			got, err := d.Status(ctx, handle)
+			if err != nil {
+				t.Fatalf("Status() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Status() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	d, _ := newTestJobDispatcher()
+	if _, err := d.Status(context.Background(), "rainbow/missing"); err == nil {
+		t.Errorf("Status() of missing job should fail")
+	}
+	if _, err := d.Status(context.Background(), "invalid"); err == nil {
+		t.Errorf("Status() of invalid handle should fail")
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	d, client := newTestJobDispatcher()
+	ctx := context.Background()
+
+	handle, err := d.Dispatch(ctx, testTask(), []byte(fmtConfig("registry")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Cancel(ctx, handle); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if _, err = client.BatchV1().Jobs("rainbow").Get(ctx, "rainbow-task-1-2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("job not deleted after Cancel(), got %v", err)
+	}
+	if err = d.Cancel(ctx, handle); err == nil {
+		t.Errorf("Cancel() of deleted job should fail")
+	}
+}
+
+func TestJobLogs(t *testing.T) {
+	d, client := newTestJobDispatcher()
+	ctx := context.Background()
+
+	handle, err := d.Dispatch(ctx, testTask(), []byte(fmtConfig("registry")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Logs(ctx, handle); err == nil {
+		t.Errorf("Logs() without pods should fail")
+	}
+
+	for i, name := range []string{"rainbow-task-1-2-old", "rainbow-task-1-2-new"} {
+		if _, err = client.CoreV1().Pods("rainbow").Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "rainbow",
+				Labels:            map[string]string{"job-name": "rainbow-task-1-2"},
+				CreationTimestamp: metav1.Unix(int64(100+i), 0),
+			},
+		}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fake clientset 返回固定的日志内容
+	logs, err := d.Logs(ctx, handle)
+	if err != nil {
+		t.Fatalf("Logs() error = %v", err)
+	}
+	if logs != "fake logs" {
+		t.Errorf("Logs() = %q", logs)
+	}
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func fmtConfig(driver string) string {
+	return fmt.Sprintf(testPluginConfig, driver)
+}
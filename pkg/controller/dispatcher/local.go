@@ -0,0 +1,227 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/controller/plugin"
+	"github.com/caoyingjunz/rainbow/pkg/controller/workspace"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/util"
+)
+
+// finishedRunRetention 运行结束后保留的时长，agent 在此期间上报运行的最终状态和日志
+const finishedRunRetention = 10 * time.Minute
+
+// localDispatcher 在 agent 所在的主机上执行插件，inProcess 为 true 时在 agent 进程内执行，否则以子进程的方式执行
+type localDispatcher struct {
+	workspace    *workspace.Manager
+	pluginBinary string
+	inProcess    bool
+	exec         exec.Interface
+	// 结束的运行超过保留时长后删除
+	retention time.Duration
+
+	lock sync.Mutex
+	runs map[string]*localRun
+}
+
+type localRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// 插件的退出码，运行结束后设置
+	exitCode int
+	logFile  string
+}
+
+func NewLocalDispatcher(ws *workspace.Manager, pluginBinary string, inProcess bool) Dispatcher {
+	return &localDispatcher{
+		workspace:    ws,
+		pluginBinary: pluginBinary,
+		inProcess:    inProcess,
+		exec:         exec.New(),
+		retention:    finishedRunRetention,
+		runs:         make(map[string]*localRun),
+	}
+}
+
+func (l *localDispatcher) Name() string {
+	if l.inProcess {
+		return LocalMode
+	}
+	return ProcessMode
+}
+
+func (l *localDispatcher) Dispatch(ctx context.Context, task model.Task, cfg []byte) (string, error) {
+	handle := fmt.Sprintf("%d-%d", task.Id, task.ResourceVersion)
+
+	destDir, err := l.workspace.TaskDir(task.Id)
+	if err != nil {
+		return "", err
+	}
+	cfgFile := filepath.Join(destDir, "config.yaml")
+	// 插件配置中包含仓库的认证信息，只允许 agent 的用户读取
+	if err = ioutil.WriteFile(cfgFile, cfg, 0600); err != nil {
+		l.workspace.Release(task.Id, true)
+		return "", err
+	}
+
+	// 运行不随 Dispatch 的 ctx 结束，只能通过 Cancel 取消
+	runCtx, cancel := context.WithCancel(context.Background())
+	run := &localRun{cancel: cancel, done: make(chan struct{})}
+
+	var start func() (int, error)
+	if l.inProcess {
+		var pluginCfg rainbowconfig.Config
+		if err = yaml.Unmarshal(cfg, &pluginCfg); err != nil {
+			cancel()
+			l.workspace.Release(task.Id, true)
+			return "", fmt.Errorf("failed to parse plugin config %v", err)
+		}
+		start = func() (int, error) { return runPlugin(pluginCfg) }
+	} else {
+		run.logFile = filepath.Join(destDir, "plugin.log")
+		logFile, err := os.Create(run.logFile)
+		if err != nil {
+			cancel()
+			l.workspace.Release(task.Id, true)
+			return "", err
+		}
+		cmd := l.exec.CommandContext(runCtx, l.pluginBinary, "--configFile", cfgFile)
+		cmd.SetDir(destDir)
+		cmd.SetStdout(logFile)
+		cmd.SetStderr(logFile)
+		start = func() (int, error) {
+			defer logFile.Close()
+			if err := cmd.Run(); err != nil {
+				if exitErr, ok := err.(exec.ExitError); ok {
+					return exitErr.ExitStatus(), err
+				}
+				return plugin.ExitFailed, err
+			}
+			return plugin.ExitSucceeded, nil
+		}
+	}
+
+	l.lock.Lock()
+	l.runs[handle] = run
+	l.lock.Unlock()
+
+	go func() {
+		defer close(run.done)
+		defer cancel()
+		var err error
+		if run.exitCode, err = start(); err != nil {
+			klog.Errorf("plugin run %s for task %d exited: %v", handle, task.Id, err)
+		}
+
+		// 插件配置中包含仓库凭据，运行结束后立即删除，子进程模式保留日志直到目录过期
+		util.RemoveFile(cfgFile)
+		l.workspace.Release(task.Id, l.inProcess)
+		time.AfterFunc(l.retention, func() { l.removeRun(handle) })
+	}()
+	return handle, nil
+}
+
+// runPlugin 返回和插件子进程一致的退出码
+func runPlugin(cfg rainbowconfig.Config) (int, error) {
+	pc := plugin.NewPluginController(cfg)
+	defer pc.Close()
+
+	if err := pc.Complete(); err != nil {
+		return plugin.ExitFailed, err
+	}
+	result, err := pc.Run()
+	if err != nil {
+		return plugin.ExitFailed, err
+	}
+	// 各镜像的结果和任务的最终状态已由插件上报，这里只记录汇总
+	klog.Infof("plugin task %d %s: %s", cfg.Plugin.TaskId, result.Status(), result)
+	return result.ExitCode(), nil
+}
+
+func (l *localDispatcher) removeRun(handle string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.runs, handle)
+}
+
+func (l *localDispatcher) getRun(handle string) (*localRun, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	run, ok := l.runs[handle]
+	if !ok {
+		return nil, fmt.Errorf("plugin run %s not found", handle)
+	}
+	return run, nil
+}
+
+// Wait 等待插件运行结束，插件的执行结果已通过回调上报，这里不再返回运行错误
+func (l *localDispatcher) Wait(ctx context.Context, handle string) error {
+	run, err := l.getRun(handle)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-run.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (l *localDispatcher) Status(ctx context.Context, handle string) (string, error) {
+	run, err := l.getRun(handle)
+	if err != nil {
+		// agent 重启后无法获取之前运行的状态
+		return UnknownStatus, nil
+	}
+
+	select {
+	case <-run.done:
+		return exitStatus(run.exitCode), nil
+	default:
+		return RunningStatus, nil
+	}
+}
+
+func (l *localDispatcher) Cancel(ctx context.Context, handle string) error {
+	if l.inProcess {
+		return notSupported(LocalMode, "cancel")
+	}
+	run, err := l.getRun(handle)
+	if err != nil {
+		return err
+	}
+
+	run.cancel()
+	return nil
+}
+
+func (l *localDispatcher) Logs(ctx context.Context, handle string) (string, error) {
+	if l.inProcess {
+		return "", notSupported(LocalMode, "logs")
+	}
+	run, err := l.getRun(handle)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(run.logFile)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
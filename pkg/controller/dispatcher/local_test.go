@@ -0,0 +1,88 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/controller/workspace"
+)
+
+// newTestProcessDispatcher 插件二进制为以 code 退出的脚本
+func newTestProcessDispatcher(t *testing.T, code int) *localDispatcher {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "plugin.sh")
+	if err := ioutil.WriteFile(binary, []byte(fmt.Sprintf("#!/bin/sh\necho syncing\nexit %d\n", code)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ws := workspace.New(filepath.Join(dir, "data"), rainbowconfig.WorkspaceOption{})
+	return NewLocalDispatcher(ws, binary, false).(*localDispatcher)
+}
+
+func TestProcessStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		want string
+	}{
+		{name: "succeeded", code: 0, want: SucceededStatus},
+		{name: "failed", code: 1, want: FailedStatus},
+		{name: "partial failed", code: 2, want: PartialFailedStatus},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestProcessDispatcher(t, tc.code)
+			ctx := context.Background()
+
+			handle, err := d.Dispatch(ctx, testTask(), []byte(fmtConfig("registry")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = d.Wait(ctx, handle); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := d.Status(ctx, handle); got != tc.want {
+				t.Errorf("Status() = %s, want %s", got, tc.want)
+			}
+			if logs, _ := d.Logs(ctx, handle); logs != "syncing\n" {
+				t.Errorf("Logs() = %q", logs)
+			}
+		})
+	}
+}
+
+func TestProcessRunRemovedAfterRetention(t *testing.T) {
+	d := newTestProcessDispatcher(t, 0)
+	d.retention = 10 * time.Millisecond
+	ctx := context.Background()
+
+	handle, err := d.Dispatch(ctx, testTask(), []byte(fmtConfig("registry")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Wait(ctx, handle); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.lock.Lock()
+		n := len(d.runs)
+		d.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("finished run not removed after retention")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, _ := d.Status(ctx, handle); got != UnknownStatus {
+		t.Errorf("Status() of removed run = %s, want %s", got, UnknownStatus)
+	}
+}
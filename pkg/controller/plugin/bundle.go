@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+	"k8s.io/klog/v2"
+
+	"github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
+// exportImages 将所有镜像写入 OCI image layout 格式的离线包，之后通过 import 命令推送到目标仓库
+type exportImages struct {
+	name string
+	p    *PluginController
+}
+
+func (e *exportImages) GetName() string {
+	return e.name
+}
+
+// Run 先解析所有镜像，全部写入离线包后才确定镜像的结果，写入失败时所有镜像都失败
+func (e *exportImages) Run() error {
+	_ = e.p.SyncTaskStatus("导出镜像中", "")
+
+	// 写入离线包的时间和镜像总大小有关，不使用单个镜像的超时时间
+	ctx := context.Background()
+	var (
+		writer  = e.p.registryClient.NewBundleWriter(e.p.platforms)
+		results []imageResult
+	)
+	for _, name := range e.p.Images {
+		_ = e.p.SyncImageStatus(name, model.ImageRunningStatus, "")
+
+		target, err := e.p.parseTargetImage(name)
+		if err != nil {
+			results = append(results, imageResult{name: name, err: err})
+			continue
+		}
+		image, err := writer.Add(ctx, name, target)
+		results = append(results, imageResult{name: name, digest: imageDigest{source: image.Digest}, err: err})
+	}
+
+	if err := writeBundleFile(ctx, writer, e.p.Cfg.Plugin.Bundle); err != nil {
+		for i := range results {
+			if results[i].err == nil {
+				results[i].err = fmt.Errorf("failed to write bundle: %v", err)
+			}
+		}
+	}
+
+	e.p.imageResults = results
+	return nil
+}
+
+// writeBundleFile 先写入临时文件，成功后再重命名，避免留下不完整的离线包
+func writeBundleFile(ctx context.Context, writer *registry.BundleWriter, path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err = writer.WriteTo(ctx, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ImportBundle 将离线包中的镜像推送到 cfg 中的目标仓库，目标镜像按照导入环境的命名规则计算
+func ImportBundle(cfg config.Config, path string) (TaskResult, error) {
+	var result TaskResult
+
+	reg := cfg.Registry
+	target := func(source string) (string, error) {
+		return registry.TargetImage(reg.Naming, reg.Repository, reg.Namespace, source)
+	}
+
+	cred, err := ResolveCredential(reg, exec.New())
+	if err != nil {
+		return result, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+
+	results, err := NewRegistryClient(reg, cred).ImportBundle(context.Background(), f, target)
+	if err != nil {
+		return result, err
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			result.Failed++
+			klog.Errorf("failed to import %s: %v", r.Source, r.Err)
+			continue
+		}
+		result.Succeeded++
+		klog.Infof("imported %s to %s (%s)", r.Source, r.Target, r.Digest)
+	}
+	return result, nil
+}
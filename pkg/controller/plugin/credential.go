@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+
+	"github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
+const (
+	credentialHelperTimeout = 30 * time.Second
+
+	// credential helper 中 docker.io 使用的地址
+	dockerHubServerURL = "https://index.docker.io/v1/"
+	// credential helper 返回该用户名时 Secret 为 identity token
+	identityTokenUsername = "<token>"
+
+	// 配置中未指定认证信息时从环境变量中读取，例如 CI 中配置的 secret
+	UsernameEnv = "RAINBOW_REGISTRY_USERNAME"
+	PasswordEnv = "RAINBOW_REGISTRY_PASSWORD"
+	TokenEnv    = "RAINBOW_REGISTRY_TOKEN"
+)
+
+// helperCredential docker credential helper get 命令的输出
+type helperCredential struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// registryHost 目标仓库的地址，未配置时为 docker.io
+func registryHost(reg config.Registry) string {
+	if len(reg.Repository) == 0 {
+		return registry.DefaultRegistry
+	}
+	return reg.Repository
+}
+
+// ResolveCredential 获取目标仓库的认证信息，认证信息只保存在内存中，不会出现在命令行参数和日志中。
+// 配置了 credential helper 时通过 docker credential helper 协议获取，否则使用配置或者环境变量中的用户名密码或者 token
+func ResolveCredential(reg config.Registry, e exec.Interface) (registry.Credential, error) {
+	if len(reg.CredentialHelper) != 0 {
+		if len(reg.Password) != 0 || len(reg.PasswordFile) != 0 || len(reg.Token) != 0 || len(reg.IdentityToken) != 0 {
+			return registry.Credential{}, fmt.Errorf("credential_helper can not be used with password, password_file or tokens")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+		defer cancel()
+		return credentialFromHelper(ctx, e, reg.CredentialHelper, registryHost(reg))
+	}
+
+	cred := registry.Credential{
+		Username:      reg.Username,
+		Password:      reg.Password,
+		IdentityToken: reg.IdentityToken,
+		RegistryToken: reg.Token,
+	}
+	// 配置中只有用户名时，密码也可以来自环境变量
+	if len(reg.Password) == 0 && len(reg.PasswordFile) == 0 && len(reg.Token) == 0 && len(reg.IdentityToken) == 0 {
+		if len(cred.Username) == 0 {
+			cred.Username = os.Getenv(UsernameEnv)
+		}
+		cred.Password, cred.RegistryToken = os.Getenv(PasswordEnv), os.Getenv(TokenEnv)
+	}
+	if len(reg.PasswordFile) != 0 {
+		if len(reg.Password) != 0 {
+			return cred, fmt.Errorf("password and password_file are mutually exclusive")
+		}
+		data, err := ioutil.ReadFile(reg.PasswordFile)
+		if err != nil {
+			return cred, fmt.Errorf("failed to read password file: %v", err)
+		}
+		cred.Password = strings.TrimRight(string(data), "\r\n")
+	}
+	if len(cred.Password) != 0 && len(cred.Username) == 0 {
+		return cred, fmt.Errorf("username is required when password is set")
+	}
+	return cred, nil
+}
+
+// credentialFromHelper 执行 docker-credential-<helper> get，仓库地址通过标准输入传递
+func credentialFromHelper(ctx context.Context, e exec.Interface, helper string, host string) (registry.Credential, error) {
+	serverURL := host
+	if host == registry.DefaultRegistry {
+		serverURL = dockerHubServerURL
+	}
+
+	var stderr bytes.Buffer
+	cmd := e.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.SetStdin(strings.NewReader(serverURL))
+	cmd.SetStderr(&stderr)
+	out, err := cmd.Output()
+	if err != nil {
+		// 未找到认证信息时 helper 将原因输出到标准输出
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) == 0 {
+			msg = strings.TrimSpace(string(out))
+		}
+		return registry.Credential{}, fmt.Errorf("credential helper %s failed for %s: %v %s", helper, host, err, msg)
+	}
+
+	var hc helperCredential
+	if err = json.Unmarshal(out, &hc); err != nil {
+		return registry.Credential{}, fmt.Errorf("failed to parse output of credential helper %s", helper)
+	}
+	if hc.Username == identityTokenUsername {
+		return registry.Credential{IdentityToken: hc.Secret}, nil
+	}
+	return registry.Credential{Username: hc.Username, Password: hc.Secret}, nil
+}
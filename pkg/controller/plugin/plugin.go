@@ -1,27 +1,41 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/caoyingjunz/pixiulib/exec"
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"k8s.io/klog/v2"
 
 	"github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/imagesource"
+	"github.com/caoyingjunz/rainbow/pkg/kubeimages"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
 	"github.com/caoyingjunz/rainbow/pkg/util"
 )
 
 const (
-	Kubeadm   = "kubeadm"
-	IgnoreKey = "W0508"
+	Kubeadm = "kubeadm"
+
+	// BuiltinResolver 使用内置的版本表解析 kubernetes 镜像，KubeadmResolver 使用节点上的 kubeadm
+	BuiltinResolver = "builtin"
+	KubeadmResolver = Kubeadm
+
+	defaultConcurrency  = 5
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 2 * time.Second
+	maxRetryBackoff     = 1 * time.Minute
+	defaultImageTimeout = 30 * time.Minute
+
+	defaultProgressInterval = 3 * time.Second
+	renderChartTimeout      = 5 * time.Minute
 )
 
 type KubeadmVersion struct {
@@ -42,112 +56,113 @@ type PluginController struct {
 
 	httpClient util.HttpInterface
 	exec       exec.Interface
-	docker     *client.Client
+	syncer     ImageSyncer
+	// 根据内置的版本表解析 kubernetes 镜像
+	kubeResolver *kubeimages.Resolver
+	// 用于获取源镜像和目标镜像的 digest
+	registryClient *registry.Client
+	// 同步的镜像平台，为空时同步所有平台
+	platforms []registry.Platform
 
 	Cfg      config.Config
 	Registry config.Registry
 	Images   []string
 
-	Runners []Runner
-}
-
-type Runner interface {
-	GetName() string
-	Run() error
+	Runners    []Runner
+	imageHooks []ImageHook
+	// copy 或者 export 步骤中每个镜像的结果，verify 步骤将校验失败的镜像标记为失败
+	imageResults []imageResult
+
+	concurrency  int
+	maxRetries   int
+	retryBackoff time.Duration
+	imageTimeout time.Duration
+	// 上报镜像同步进度的间隔
+	progressInterval time.Duration
 }
 
-type login struct {
-	name string
-	p    *PluginController
-}
-
-func (l *login) GetName() string {
-	return l.name
+// newCallbackClient 回调 server 的客户端，server 非 debug 模式下要求携带任务的回调 token
+func newCallbackClient(opt config.PluginOption) util.HttpInterface {
+	var header map[string]string
+	if len(opt.CallbackToken) != 0 {
+		header = map[string]string{"Authorization": "Bearer " + opt.CallbackToken}
+	}
+	return util.NewHttpClientWithHeader(5*time.Second, opt.Callback, header)
 }
 
-func (l *login) Run() error {
-	cmd := []string{"docker", "login", "-u", l.p.Registry.Username, "-p", l.p.Registry.Password}
-	if l.p.Registry.Repository != "" {
-		cmd = append(cmd, l.p.Registry.Repository)
+func NewPluginController(cfg config.Config) *PluginController {
+	p := &PluginController{
+		Cfg:          cfg,
+		Callback:     cfg.Plugin.Callback,
+		TaskId:       cfg.Plugin.TaskId,
+		Synced:       cfg.Plugin.Synced,
+		exec:         exec.New(),
+		httpClient:   newCallbackClient(cfg.Plugin),
+		concurrency:  cfg.Plugin.Concurrency,
+		maxRetries:   cfg.Plugin.MaxRetries,
+		retryBackoff: cfg.Plugin.RetryBackoff,
+		imageTimeout: cfg.Plugin.ImageTimeout,
+
+		progressInterval: cfg.Plugin.ProgressInterval,
 	}
-	out, err := l.p.exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to login in image %v %v", string(out), err)
+	if p.concurrency <= 0 {
+		p.concurrency = defaultConcurrency
 	}
-	return nil
-}
-
-type image struct {
-	name string
-	p    *PluginController
-}
-
-func (i *image) GetName() string {
-	return i.name
-}
-
-func (i *image) Run() error {
-	var images []string
-	if i.p.Cfg.Default.PushKubernetes {
-		kubeImages, err := i.p.getImages()
-		if err != nil {
-			return fmt.Errorf("获取 k8s 镜像失败: %v", err)
-		}
-		images = append(images, kubeImages...)
+	if p.maxRetries == 0 {
+		p.maxRetries = defaultMaxRetries
 	}
-
-	if i.p.Cfg.Default.PushImages {
-		fileImages, err := i.p.getImagesFromFile()
-		if err != nil {
-			return fmt.Errorf("")
-		}
-		images = append(images, fileImages...)
+	if p.retryBackoff <= 0 {
+		p.retryBackoff = defaultRetryBackoff
 	}
-
-	i.p.Images = images
-	return nil
-}
-
-func NewPluginController(cfg config.Config) *PluginController {
-	return &PluginController{
-		Cfg:        cfg,
-		Callback:   cfg.Plugin.Callback,
-		TaskId:     cfg.Plugin.TaskId,
-		Synced:     cfg.Plugin.Synced,
-		httpClient: util.NewHttpClient(5*time.Second, cfg.Plugin.Callback),
+	if p.imageTimeout <= 0 {
+		p.imageTimeout = defaultImageTimeout
+	}
+	if p.progressInterval <= 0 {
+		p.progressInterval = defaultProgressInterval
 	}
+	return p
 }
 
 func (p *PluginController) Validate() error {
-	if p.Cfg.Default.PushKubernetes {
-		if len(p.KubernetesVersion) == 0 {
-			return fmt.Errorf("failed to find kubernetes version")
-		}
-		// 检查 kubeadm 的版本是否和 k8s 版本一致
-		kubeadmVersion, err := p.getKubeadmVersion()
-		if err != nil {
-			return fmt.Errorf("failed to get kubeadm version: %v", err)
+	if !p.Cfg.Default.PushKubernetes {
+		return nil
+	}
+	if len(p.KubernetesVersion) == 0 {
+		return fmt.Errorf("failed to find kubernetes version")
+	}
+
+	switch p.Cfg.Kubernetes.Resolver {
+	case "":
+		// 版本表中没有的版本才需要 kubeadm
+		if p.kubeResolver.Supports(p.KubernetesVersion) {
+			return nil
 		}
-		if kubeadmVersion != p.KubernetesVersion {
-			return fmt.Errorf("kubeadm version %s not match kubernetes version %s", kubeadmVersion, p.KubernetesVersion)
+		klog.Infof("kubernetes %s is not in the version table, falling back to %s", p.KubernetesVersion, Kubeadm)
+		return p.checkKubeadmVersion()
+	case BuiltinResolver:
+		if !p.kubeResolver.Supports(p.KubernetesVersion) {
+			return fmt.Errorf("kubernetes %s is not in the version table, supported versions: %v", p.KubernetesVersion, p.kubeResolver.MinorVersions())
 		}
+		return nil
+	case KubeadmResolver:
+		return p.checkKubeadmVersion()
 	}
+	return fmt.Errorf("unsupported kubernetes image resolver %s", p.Cfg.Kubernetes.Resolver)
+}
 
-	// 检查 docker 的客户端是否正常
-	if _, err := p.docker.Ping(context.Background()); err != nil {
-		return err
+// checkKubeadmVersion 检查 kubeadm 的版本是否和 k8s 版本一致，kubeadm 需要提前安装
+func (p *PluginController) checkKubeadmVersion() error {
+	kubeadmVersion, err := p.getKubeadmVersion()
+	if err != nil {
+		return fmt.Errorf("failed to get kubeadm version: %v", err)
+	}
+	if kubeadmVersion != p.KubernetesVersion {
+		return fmt.Errorf("kubeadm version %s not match kubernetes version %s", kubeadmVersion, p.KubernetesVersion)
 	}
-
 	return nil
 }
 
 func (p *PluginController) doComplete() error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return err
-	}
-	p.docker = cli
-
 	if p.Cfg.Default.PushKubernetes {
 		if len(p.KubernetesVersion) == 0 {
 			if len(p.Cfg.Kubernetes.Version) != 0 {
@@ -156,30 +171,41 @@ func (p *PluginController) doComplete() error {
 				p.KubernetesVersion = os.Getenv("KubernetesVersion")
 			}
 		}
-	}
-
-	if p.Cfg.Default.PushKubernetes {
-		//cmd := []string{"sudo", "apt-get", "install", "-y", fmt.Sprintf("kubeadm=%s-00", p.Cfg.Kubernetes.Version[1:])}
-		cmd := []string{"sudo", "curl", "-LO", fmt.Sprintf("https://dl.k8s.io/release/%s/bin/linux/amd64/kubeadm", p.Cfg.Kubernetes.Version)}
-		klog.Infof("Starting install kubeadm", cmd)
-		out, err := p.exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("failed to get kubeadm %v %v", string(out), err)
-		}
 
-		cmd2 := []string{"sudo", "install", "-o", "root", "-g", "root", "-m", "0755", "kubeadm", "/usr/local/bin/kubeadm"}
-		out, err = p.exec.Command(cmd2[0], cmd2[1:]...).CombinedOutput()
+		// 内置的版本表不需要下载 kubeadm，也不需要 root 权限
+		resolver, err := kubeimages.NewResolver(p.Cfg.Kubernetes.VersionTable)
 		if err != nil {
-			return fmt.Errorf("failed to install kubeadm %v %v", string(out), err)
+			return err
 		}
+		p.kubeResolver = resolver
 	}
 
-	p.exec = exec.New()
 	p.Registry = p.Cfg.Registry
 
-	p.Runners = []Runner{
-		&login{name: "Registry登陆", p: p},
-		&image{name: "解析镜像", p: p},
+	platforms, err := registry.ParsePlatforms(strings.Join(p.Cfg.Default.Platforms, ","))
+	if err != nil {
+		return err
+	}
+	p.platforms = platforms
+
+	// 认证信息只解析一次，由 registry 客户端和 docker 驱动共用
+	cred, err := ResolveCredential(p.Registry, p.exec)
+	if err != nil {
+		return err
+	}
+	klog.Infof("using %s for registry %s", cred, registryHost(p.Registry))
+	p.registryClient = NewRegistryClient(p.Registry, cred)
+	syncer, err := NewImageSyncer(p.Cfg.Plugin.Driver, p.registryClient, p.Registry, cred, platforms)
+	if err != nil {
+		return err
+	}
+	p.syncer = syncer
+
+	if p.Runners, err = newRunners(p, p.Cfg.Plugin.Stages); err != nil {
+		return err
+	}
+	if p.imageHooks, err = newImageHooks(p, p.Cfg.Plugin.ImageHooks); err != nil {
+		return err
 	}
 	return p.Validate()
 }
@@ -197,8 +223,8 @@ func (p *PluginController) Complete() error {
 }
 
 func (p *PluginController) Close() {
-	if p.docker != nil {
-		_ = p.docker.Close()
+	if p.syncer != nil {
+		p.syncer.Close()
 	}
 }
 
@@ -222,33 +248,27 @@ func (p *PluginController) getKubeadmVersion() (string, error) {
 	return kubeadmVersion.ClientVersion.GitVersion, nil
 }
 
-func (p *PluginController) cleanImages(in []byte) []byte {
-	inStr := string(in)
-	if !strings.Contains(inStr, IgnoreKey) {
-		return in
-	}
-
-	klog.V(2).Infof("cleaning images: %+v", inStr)
-	parts := strings.Split(inStr, "\n")
-	index := 0
-	for _, p := range parts {
-		if strings.HasPrefix(p, IgnoreKey) {
-			index += 1
-		}
+// getKubernetesImages 优先使用内置的版本表，版本表中没有的版本使用 kubeadm 解析
+func (p *PluginController) getKubernetesImages() ([]string, error) {
+	if p.Cfg.Kubernetes.Resolver != KubeadmResolver && p.kubeResolver.Supports(p.KubernetesVersion) {
+		return p.kubeResolver.Images(p.KubernetesVersion, p.Cfg.Kubernetes.ImageRepository)
 	}
-	newInStr := strings.Join(parts[index:], "\n")
-	klog.V(2).Infof("cleaned images: %+v", newInStr)
-
-	return []byte(newInStr)
+	return p.getKubeadmImages()
 }
 
-func (p *PluginController) getImages() ([]string, error) {
+func (p *PluginController) getKubeadmImages() ([]string, error) {
 	cmd := []string{Kubeadm, "config", "images", "list", "--kubernetes-version", p.KubernetesVersion, "-o", "json"}
-	out, err := p.exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	if len(p.Cfg.Kubernetes.ImageRepository) != 0 {
+		cmd = append(cmd, "--image-repository", p.Cfg.Kubernetes.ImageRepository)
+	}
+	// 只解析标准输出，kubeadm 的告警信息输出在标准错误中
+	var stderr bytes.Buffer
+	command := p.exec.Command(cmd[0], cmd[1:]...)
+	command.SetStderr(&stderr)
+	out, err := command.Output()
 	if err != nil {
-		return nil, fmt.Errorf("failed to exec kubeadm config images list %v %v", string(out), err)
+		return nil, fmt.Errorf("failed to exec kubeadm config images list %v %v", stderr.String(), err)
 	}
-	out = p.cleanImages(out)
 	klog.V(2).Infof("images is %+v", string(out))
 
 	var kubeadmImage KubeadmImage
@@ -260,44 +280,97 @@ func (p *PluginController) getImages() ([]string, error) {
 }
 
 func (p *PluginController) parseTargetImage(imageToPush string) (string, error) {
-	// real image to push
-	parts := strings.Split(imageToPush, "/")
+	return registry.TargetImage(p.Registry.Naming, p.Registry.Repository, p.Registry.Namespace, imageToPush)
+}
 
-	return p.Registry.Repository + "/" + p.Registry.Namespace + "/" + parts[len(parts)-1], nil
+// imageDigest 镜像同步前后记录的 digest
+type imageDigest struct {
+	source string
+	target string
 }
 
-func (p *PluginController) doPushImage(imageToPush string) error {
+// doPushImage 同步镜像并记录推送的 digest，目标仓库已存在相同镜像时跳过同步并返回 true
+func (p *PluginController) doPushImage(ctx context.Context, imageToPush string, progress ProgressFunc) (imageDigest, bool, error) {
+	var digest imageDigest
+
 	targetImage, err := p.parseTargetImage(imageToPush)
 	if err != nil {
-		return err
+		return digest, false, err
 	}
-
-	klog.Infof("starting pull image %s", imageToPush)
-	// start pull
-	reader, err := p.docker.ImagePull(context.TODO(), imageToPush, types.ImagePullOptions{})
+	src, err := registry.ParseReference(imageToPush)
 	if err != nil {
-		klog.Errorf("failed to pull %s: %v", imageToPush, err)
-		return err
+		return digest, false, err
 	}
-	io.Copy(os.Stdout, reader)
-
-	klog.Infof("tag %s to %s", imageToPush, targetImage)
-	if err := p.docker.ImageTag(context.TODO(), imageToPush, targetImage); err != nil {
-		klog.Errorf("failed to tag %s to %s: %v", imageToPush, targetImage, err)
-		return err
+	dst, err := registry.ParseReference(targetImage)
+	if err != nil {
+		return digest, false, err
+	}
+	if digest.source, err = p.registryClient.ManifestDigest(ctx, src.Registry, src.Repository, src.Identifier()); err != nil {
+		return digest, false, fmt.Errorf("failed to get source digest of %s: %w", imageToPush, err)
+	}
+	for _, hook := range p.imageHooks {
+		if err = hook.PreSync(ctx, imageToPush, targetImage); err != nil {
+			return digest, false, fmt.Errorf("image hook %s rejected %s: %w", hook.GetName(), imageToPush, err)
+		}
 	}
 
-	klog.Infof("starting push image %s", targetImage)
+	// 按 digest 同步，保证记录的源镜像就是实际同步的镜像
+	pinned := registry.Reference{Registry: src.Registry, Repository: src.Repository, Digest: digest.source}
 
-	cmd := []string{"docker", "push", targetImage}
-	out, err := p.exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	// 目标镜像和将要推送的镜像 digest 一致时无需同步，不同驱动推送的 manifest 不同，由驱动计算
+	if !p.Cfg.Default.Force {
+		expected, err := p.syncer.TargetDigest(ctx, pinned)
+		if err != nil {
+			return digest, false, fmt.Errorf("failed to get expected target digest of %s: %w", imageToPush, err)
+		}
+		existing, err := p.registryClient.ManifestDigest(ctx, dst.Registry, dst.Repository, dst.Identifier())
+		if err != nil && err != registry.ErrNotFound {
+			return digest, false, fmt.Errorf("failed to get target digest of %s: %w", targetImage, err)
+		}
+		if existing == expected {
+			digest.target = existing
+			return digest, true, nil
+		}
+	}
+
+	pushed, err := p.syncer.Sync(ctx, pinned.String(), targetImage, progress)
 	if err != nil {
-		return fmt.Errorf("failed to push image %s %v %v", targetImage, string(out), err)
+		return digest, false, err
 	}
 
-	klog.Infof("complete push image %s", imageToPush)
+	// 过滤平台或者通过 docker 推送时，目标镜像的 digest 以实际推送的 manifest 为准，由 verify 步骤校验
+	digest.target = digest.source
+	if len(pushed) != 0 {
+		digest.target = pushed
+	}
+	for _, hook := range p.imageHooks {
+		if err = hook.PostSync(ctx, imageToPush, targetImage, digest.target); err != nil {
+			return digest, false, fmt.Errorf("image hook %s failed after syncing %s: %w", hook.GetName(), imageToPush, err)
+		}
+	}
+	return digest, false, nil
+}
+
+// verifyImage 校验目标仓库中镜像的 digest 和推送的 digest 一致
+func (p *PluginController) verifyImage(ctx context.Context, imageToPush string, expected string) error {
+	targetImage, err := p.parseTargetImage(imageToPush)
+	if err != nil {
+		return err
+	}
+	dst, err := registry.ParseReference(targetImage)
+	if err != nil {
+		return err
+	}
+	actual, err := p.registryClient.ManifestDigest(ctx, dst.Registry, dst.Repository, dst.Identifier())
+	if err != nil {
+		return fmt.Errorf("failed to get target digest of %s: %w", targetImage, err)
+	}
+	if actual != expected {
+		return fmt.Errorf("target digest %s does not match expected digest %s", actual, expected)
+	}
 	return nil
 }
+
 func (p *PluginController) getImagesFromFile() ([]string, error) {
 	var imgs []string
 	for _, i := range p.Cfg.Images {
@@ -305,8 +378,11 @@ func (p *PluginController) getImagesFromFile() ([]string, error) {
 		if len(imageStr) == 0 {
 			continue
 		}
+		// 版本范围中允许出现空格，例如 redis:>=7.0 <8
 		if strings.Contains(imageStr, " ") {
-			return nil, fmt.Errorf("error image format: %s", imageStr)
+			if _, ok, _ := registry.ParseTagPattern(imageStr); !ok {
+				return nil, fmt.Errorf("error image format: %s", imageStr)
+			}
 		}
 
 		imgs = append(imgs, imageStr)
@@ -315,46 +391,100 @@ func (p *PluginController) getImagesFromFile() ([]string, error) {
 	return imgs, nil
 }
 
-func (p *PluginController) Run() error {
+// getImagesFromSources 从配置的 helm chart 和 kubernetes manifest 中提取镜像
+func (p *PluginController) getImagesFromSources() ([]string, error) {
+	var images []string
+	if len(p.Cfg.Manifests) != 0 {
+		found, err := imagesource.FromPaths(p.Cfg.Manifests)
+		if err != nil {
+			return nil, fmt.Errorf("解析 manifest 镜像失败: %v", err)
+		}
+		images = append(images, found...)
+	}
+
+	for _, chart := range p.Cfg.Charts {
+		ctx, cancel := context.WithTimeout(context.Background(), renderChartTimeout)
+		found, err := imagesource.FromChart(ctx, p.exec, chart)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("解析 chart 镜像失败: %v", err)
+		}
+		images = append(images, found...)
+	}
+	return images, nil
+}
+
+// Run 依次执行所有步骤并上报任务的最终状态，执行步骤失败时返回错误
+func (p *PluginController) Run() (TaskResult, error) {
 	for _, runner := range p.Runners {
 		name := runner.GetName()
 		if err := runner.Run(); err != nil {
-			_ = p.SyncTaskStatus(name, name+"失败")
-			return err
+			_ = p.SyncTaskStatus(model.TaskRunFailedStatus, fmt.Sprintf("%s失败: %v", name, err))
+			return newTaskResult(p.imageResults), err
 		}
 		_ = p.SyncTaskStatus(name, name+"完成")
 	}
 
+	result := newTaskResult(p.imageResults)
+	_ = p.SyncTaskStatus(result.Status(), result.String())
+	return result, nil
+}
+
+// syncImages 同步所有镜像，按照 p.Images 的顺序返回每个镜像的结果
+func (p *PluginController) syncImages() []imageResult {
 	_ = p.SyncTaskStatus("推送镜像中", "")
-	defer p.SyncTaskStatus("镜像推送结束", "")
 
 	diff := len(p.Images)
-	errCh := make(chan error, diff)
+	results := make([]imageResult, diff)
 
+	// 限制同时同步的镜像数量，避免触发源仓库的限流
+	sem := make(chan struct{}, p.concurrency)
 	var wg sync.WaitGroup
 	wg.Add(diff)
-	for _, i := range p.Images {
-		go func(imageToPush string) {
-			defer wg.Done()
-			_ = p.SyncImageStatus(imageToPush, "进行中", "")
-			if err := p.doPushImage(imageToPush); err != nil {
-				_ = p.SyncImageStatus(imageToPush, "异常", err.Error())
-				errCh <- err
-			}
-			_ = p.SyncImageStatus(imageToPush, "完成", "")
-		}(i)
+	for i, image := range p.Images {
+		sem <- struct{}{}
+		go func(i int, imageToPush string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_ = p.SyncImageStatus(imageToPush, model.ImageRunningStatus, "")
+			digest, skipped, err := p.pushImageWithRetry(imageToPush)
+			results[i] = imageResult{name: imageToPush, digest: digest, skipped: skipped, err: err}
+		}(i, image)
 	}
 	wg.Wait()
 
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return err
+	return results
+}
+
+// pushImageWithRetry 同步镜像，遇到临时错误时按照指数退避重试，所有重试共享单个镜像的超时时间
+func (p *PluginController) pushImageWithRetry(imageToPush string) (imageDigest, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.imageTimeout)
+	defer cancel()
+
+	reporter := p.newProgressReporter(imageToPush)
+	defer reporter.stop()
+
+	backoff := p.retryBackoff
+	for attempt := 1; ; attempt++ {
+		digest, skipped, err := p.doPushImage(ctx, imageToPush, reporter.update)
+		if err == nil || attempt > p.maxRetries || !registry.IsTransient(err) {
+			return digest, skipped, err
 		}
-	default:
-	}
 
-	return nil
+		klog.Warningf("failed to push image %s (attempt %d), retrying in %v: %v", imageToPush, attempt, backoff, err)
+		_ = p.SyncImageStatus(imageToPush, model.ImageRunningStatus, fmt.Sprintf("第 %d 次重试: %v", attempt, err))
+		select {
+		case <-ctx.Done():
+			return digest, skipped, fmt.Errorf("timed out after %v: %v", p.imageTimeout, err)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
 }
 
 func (p *PluginController) SyncTaskStatus(status, msg string) error {
@@ -367,12 +497,16 @@ func (p *PluginController) SyncTaskStatus(status, msg string) error {
 		map[string]interface{}{"status": status, "message": msg})
 }
 
-func (p *PluginController) SyncImageStatus(name, status, msg string) error {
+// SyncImageStatus 上报镜像的同步状态，digest 为空时不更新已记录的 digest
+func (p *PluginController) SyncImageStatus(name, status, msg string, digests ...imageDigest) error {
 	if !p.Synced {
 		return nil
 	}
-	return p.httpClient.Put(
-		fmt.Sprintf("%s/rainbow/images/status", p.Callback),
-		nil,
-		map[string]interface{}{"status": status, "message": msg, "task_id": p.TaskId, "name": name})
+
+	data := map[string]interface{}{"status": status, "message": msg, "task_id": p.TaskId, "name": name}
+	for _, digest := range digests {
+		data["source_digest"] = digest.source
+		data["target_digest"] = digest.target
+	}
+	return p.httpClient.Put(fmt.Sprintf("%s/rainbow/images/status", p.Callback), nil, data)
 }
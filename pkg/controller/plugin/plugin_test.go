@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
+// manifestServer 只提供 manifest 接口的 registry，按 repository:reference 保存 manifest
+type manifestServer struct {
+	lock      sync.Mutex
+	manifests map[string]registry.RawManifest
+}
+
+func newManifestServer(t *testing.T) (*manifestServer, string) {
+	m := &manifestServer{manifests: make(map[string]registry.RawManifest)}
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	return m, strings.TrimPrefix(srv.URL, "http://")
+}
+
+func (m *manifestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	i := strings.Index(p, "/manifests/")
+	if i < 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	key := p[:i] + ":" + p[i+len("/manifests/"):]
+	if r.Method == http.MethodPut {
+		body, _ := ioutil.ReadAll(r.Body)
+		m.add(key, registry.RawManifest{MediaType: r.Header.Get("Content-Type"), Digest: registry.Digest(body), Body: body})
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	manifest, ok := m.manifests[key]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`))
+		return
+	}
+	w.Header().Set("Content-Type", manifest.MediaType)
+	w.Header().Set("Docker-Content-Digest", manifest.Digest)
+	w.Header().Set("Content-Length", fmt.Sprint(len(manifest.Body)))
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(manifest.Body)
+	}
+}
+
+// add 同时按 tag 和 digest 保存 manifest
+func (m *manifestServer) add(key string, manifest registry.RawManifest) {
+	m.manifests[key] = manifest
+	m.manifests[key[:strings.LastIndex(key, ":")]+":"+manifest.Digest] = manifest
+}
+
+func newManifest(t *testing.T, v interface{}, mediaType string) registry.RawManifest {
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry.RawManifest{MediaType: mediaType, Digest: registry.Digest(body), Body: body}
+}
+
+// platformSyncer 模拟 docker 驱动，只推送 index 中单个平台的镜像
+type platformSyncer struct {
+	client *registry.Client
+	pushed registry.RawManifest
+	// 不为空时 Sync 返回该 digest 而不是实际推送的 digest
+	reported string
+
+	lock  sync.Mutex
+	syncs int
+}
+
+func (s *platformSyncer) Name() string { return "fake" }
+
+func (s *platformSyncer) TargetDigest(ctx context.Context, source registry.Reference) (string, error) {
+	return s.pushed.Digest, nil
+}
+
+func (s *platformSyncer) Sync(ctx context.Context, source string, target string, progress ProgressFunc) (string, error) {
+	s.lock.Lock()
+	s.syncs++
+	s.lock.Unlock()
+
+	dst, err := registry.ParseReference(target)
+	if err != nil {
+		return "", err
+	}
+	pushed := s.pushed
+	if err = s.client.PutManifest(ctx, dst.Registry, dst.Repository, dst.Identifier(), &pushed); err != nil {
+		return "", err
+	}
+	if len(s.reported) != 0 {
+		return s.reported, nil
+	}
+	return pushed.Digest, nil
+}
+
+func (s *platformSyncer) Close() {}
+
+func TestDoPushImageSkipExisting(t *testing.T) {
+	child := newManifest(t, registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest,
+		Config: registry.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: registry.Digest([]byte("{}")), Size: 2}}, registry.MediaTypeOCIManifest)
+	index := newManifest(t, registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{
+		{MediaType: registry.MediaTypeOCIManifest, Digest: child.Digest, Size: int64(len(child.Body)), Platform: &registry.Platform{OS: "linux", Architecture: "amd64"}},
+	}}, registry.MediaTypeOCIIndex)
+	older := newManifest(t, registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}, registry.MediaTypeOCIManifest)
+
+	tests := []struct {
+		name     string
+		existing *registry.RawManifest
+		force    bool
+		wantSkip bool
+	}{
+		{name: "target missing"},
+		// 目标镜像和驱动将要推送的平台镜像一致
+		{name: "target up to date", existing: &child, wantSkip: true},
+		{name: "target outdated", existing: &older},
+		// docker 驱动推送的不是 index，目标镜像和源 index 一致反而说明不是该驱动推送的
+		{name: "target is source index", existing: &index},
+		{name: "force", existing: &child, force: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, host := newManifestServer(t)
+			srv.add("library/nginx:1.25", index)
+
+			client := registry.NewClient()
+			syncer := &platformSyncer{client: client, pushed: child}
+			p := &PluginController{
+				registryClient: client,
+				syncer:         syncer,
+				Registry:       config.Registry{Repository: host, Namespace: "mirror"},
+			}
+			p.Cfg.Default.Force = tc.force
+
+			image := host + "/library/nginx:1.25"
+			targetImage, err := p.parseTargetImage(image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.existing != nil {
+				dst, _ := registry.ParseReference(targetImage)
+				srv.add(dst.Repository+":"+dst.Identifier(), *tc.existing)
+			}
+
+			digest, skipped, err := p.doPushImage(context.Background(), image, nil)
+			if err != nil {
+				t.Fatalf("doPushImage() error = %v", err)
+			}
+			if skipped != tc.wantSkip {
+				t.Errorf("doPushImage() skipped = %v, want %v", skipped, tc.wantSkip)
+			}
+			wantSyncs := 1
+			if tc.wantSkip {
+				wantSyncs = 0
+			}
+			if syncer.syncs != wantSyncs {
+				t.Errorf("synced %d times, want %d", syncer.syncs, wantSyncs)
+			}
+			if digest.source != index.Digest || digest.target != child.Digest {
+				t.Errorf("doPushImage() digest = %+v, want source %s and target %s", digest, index.Digest, child.Digest)
+			}
+		})
+	}
+}
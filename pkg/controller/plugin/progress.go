@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// 镜像同步的阶段，registry 驱动只有复制阶段，docker 驱动先拉取再推送
+const (
+	CopyPhase = "copy"
+	PullPhase = "pull"
+	PushPhase = "push"
+)
+
+// Progress 镜像在当前阶段已传输和需要传输的字节数
+type Progress struct {
+	Phase   string
+	Current int64
+	Total   int64
+}
+
+type ProgressFunc func(Progress)
+
+// progressReporter 记录单个镜像的最新进度，并按固定间隔上报，避免频繁回调 server
+type progressReporter struct {
+	p    *PluginController
+	name string
+
+	lock     sync.Mutex
+	latest   Progress
+	reported Progress
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// newProgressReporter 不需要上报时返回 nil，nil 的 reporter 可以直接使用
+func (p *PluginController) newProgressReporter(name string) *progressReporter {
+	if !p.Synced {
+		return nil
+	}
+
+	r := &progressReporter{
+		p:      p,
+		name:   name,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go r.run(p.progressInterval)
+	return r
+}
+
+func (r *progressReporter) update(progress Progress) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.latest = progress
+}
+
+func (r *progressReporter) run(interval time.Duration) {
+	defer close(r.doneCh)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.report()
+		case <-r.stopCh:
+			r.report()
+			return
+		}
+	}
+}
+
+func (r *progressReporter) report() {
+	r.lock.Lock()
+	progress := r.latest
+	if progress == r.reported {
+		r.lock.Unlock()
+		return
+	}
+	r.reported = progress
+	r.lock.Unlock()
+
+	if err := r.p.SyncImageProgress(r.name, progress); err != nil {
+		klog.Warningf("failed to report progress of image %s: %v", r.name, err)
+	}
+}
+
+// stop 上报最后一次进度后退出
+func (r *progressReporter) stop() {
+	if r == nil {
+		return
+	}
+	close(r.stopCh)
+	<-r.doneCh
+}
+
+func (p *PluginController) SyncImageProgress(name string, progress Progress) error {
+	if !p.Synced {
+		return nil
+	}
+	return p.httpClient.Put(
+		fmt.Sprintf("%s/rainbow/images/progress", p.Callback),
+		nil,
+		map[string]interface{}{
+			"task_id":     p.TaskId,
+			"name":        name,
+			"phase":       progress.Phase,
+			"bytes_done":  progress.Current,
+			"bytes_total": progress.Total,
+		})
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+)
+
+// 插件的退出码，和任务的最终状态对应
+const (
+	ExitSucceeded     = 0
+	ExitFailed        = 1
+	ExitPartialFailed = 2
+)
+
+// imageResult 单个镜像的最终同步结果
+type imageResult struct {
+	name    string
+	digest  imageDigest
+	skipped bool
+	err     error
+}
+
+// TaskResult 任务中所有镜像的同步结果汇总
+type TaskResult struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+}
+
+// Status 没有处理任何镜像时为失败，例如镜像列表为空或者离线包中没有镜像，
+// 否则没有失败的镜像时为成功，全部失败时为失败，其余为部分失败
+func (r TaskResult) Status() string {
+	switch {
+	case r.Total() == 0:
+		return model.TaskRunFailedStatus
+	case r.Failed == 0:
+		return model.TaskSucceededStatus
+	case r.Succeeded+r.Skipped == 0:
+		return model.TaskRunFailedStatus
+	}
+	return model.TaskPartialFailedStatus
+}
+
+func (r TaskResult) ExitCode() int {
+	switch r.Status() {
+	case model.TaskSucceededStatus:
+		return ExitSucceeded
+	case model.TaskPartialFailedStatus:
+		return ExitPartialFailed
+	}
+	return ExitFailed
+}
+
+func (r TaskResult) Total() int {
+	return r.Succeeded + r.Failed + r.Skipped
+}
+
+func (r TaskResult) String() string {
+	if r.Total() == 0 {
+		return "没有需要同步的镜像"
+	}
+	return fmt.Sprintf("成功 %d, 失败 %d, 跳过 %d", r.Succeeded, r.Failed, r.Skipped)
+}
+
+func newTaskResult(results []imageResult) TaskResult {
+	var r TaskResult
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			r.Failed++
+		case result.skipped:
+			r.Skipped++
+		default:
+			r.Succeeded++
+		}
+	}
+	return r
+}
+
+// reportImageResult 上报镜像的最终状态和 digest
+func (p *PluginController) reportImageResult(r imageResult) {
+	switch {
+	case r.err != nil:
+		_ = p.SyncImageStatus(r.name, model.ImageFailedStatus, r.err.Error(), r.digest)
+	case r.skipped:
+		_ = p.SyncImageStatus(r.name, model.ImageSkippedStatus, "skipped: target already has digest "+r.digest.target, r.digest)
+	default:
+		_ = p.SyncImageStatus(r.name, model.ImageSucceededStatus, "", r.digest)
+	}
+}
@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+)
+
+func TestTaskResultStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   TaskResult
+		want     string
+		wantCode int
+	}{
+		{name: "no images", result: TaskResult{}, want: model.TaskRunFailedStatus, wantCode: ExitFailed},
+		{name: "succeeded", result: TaskResult{Succeeded: 2}, want: model.TaskSucceededStatus, wantCode: ExitSucceeded},
+		{name: "all skipped", result: TaskResult{Skipped: 3}, want: model.TaskSucceededStatus, wantCode: ExitSucceeded},
+		{name: "all failed", result: TaskResult{Failed: 2}, want: model.TaskRunFailedStatus, wantCode: ExitFailed},
+		{name: "partial failed", result: TaskResult{Succeeded: 1, Failed: 1}, want: model.TaskPartialFailedStatus, wantCode: ExitPartialFailed},
+		{name: "skipped and failed", result: TaskResult{Skipped: 1, Failed: 1}, want: model.TaskPartialFailedStatus, wantCode: ExitPartialFailed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.result.Status(); got != tc.want {
+				t.Errorf("Status() = %s, want %s", got, tc.want)
+			}
+			if got := tc.result.ExitCode(); got != tc.wantCode {
+				t.Errorf("ExitCode() = %d, want %d", got, tc.wantCode)
+			}
+		})
+	}
+}
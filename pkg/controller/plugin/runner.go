@@ -0,0 +1,303 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// 内置的执行步骤，按照插件配置中的 stages 顺序执行
+const (
+	PreflightStage = "preflight"
+	LoginStage     = "login"
+	ResolveStage   = "resolve"
+	CopyStage      = "copy"
+	// VerifyStage 校验目标仓库中镜像的 digest，ReportStage 上报每个镜像的最终状态
+	VerifyStage  = "verify"
+	ReportStage  = "report"
+	CleanupStage = "cleanup"
+	// ExportStage 将镜像写入离线包，用于无法直接访问目标仓库的环境
+	ExportStage = "export"
+
+	preflightTimeout  = 30 * time.Second
+	expandTagsTimeout = time.Minute
+)
+
+// DefaultStages 未配置 stages 时执行的步骤
+var DefaultStages = []string{PreflightStage, LoginStage, ResolveStage, CopyStage, VerifyStage, ReportStage, CleanupStage}
+
+// Runner 插件的执行步骤，GetName 返回上报给 server 的步骤名称
+type Runner interface {
+	GetName() string
+	Run() error
+}
+
+// ImageHook 在每个镜像同步前后执行，例如检查镜像是否符合安全策略。
+// PreSync 在检查目标镜像是否已存在之前执行，返回错误时该镜像同步失败；PostSync 只在镜像推送成功后执行，digest 为推送的 manifest digest
+type ImageHook interface {
+	GetName() string
+	PreSync(ctx context.Context, source string, target string) error
+	PostSync(ctx context.Context, source string, target string, digest string) error
+}
+
+type RunnerFactory func(p *PluginController) Runner
+
+type ImageHookFactory func(p *PluginController) (ImageHook, error)
+
+// 需要在 init 中注册，插件运行时只读
+var (
+	runnerFactories    = map[string]RunnerFactory{}
+	imageHookFactories = map[string]ImageHookFactory{}
+)
+
+// RegisterRunner 注册执行步骤，name 为插件配置中使用的名称，重复注册时 panic
+func RegisterRunner(name string, factory RunnerFactory) {
+	if _, ok := runnerFactories[name]; ok {
+		panic(fmt.Sprintf("runner %s already registered", name))
+	}
+	runnerFactories[name] = factory
+}
+
+// RegisterImageHook 注册镜像钩子，name 为插件配置中使用的名称，重复注册时 panic
+func RegisterImageHook(name string, factory ImageHookFactory) {
+	if _, ok := imageHookFactories[name]; ok {
+		panic(fmt.Sprintf("image hook %s already registered", name))
+	}
+	imageHookFactories[name] = factory
+}
+
+func init() {
+	RegisterRunner(PreflightStage, func(p *PluginController) Runner { return &preflight{name: "环境检查", p: p} })
+	RegisterRunner(LoginStage, func(p *PluginController) Runner { return &login{name: "Registry登陆", p: p} })
+	RegisterRunner(ResolveStage, func(p *PluginController) Runner { return &image{name: "解析镜像", p: p} })
+	RegisterRunner(CopyStage, func(p *PluginController) Runner { return &copyImages{name: "推送镜像", p: p} })
+	RegisterRunner(VerifyStage, func(p *PluginController) Runner { return &verifyImages{name: "校验镜像", p: p} })
+	RegisterRunner(ReportStage, func(p *PluginController) Runner { return &report{name: "上报结果", p: p} })
+	RegisterRunner(CleanupStage, func(p *PluginController) Runner { return &cleanup{name: "清理环境", p: p} })
+	RegisterRunner(ExportStage, func(p *PluginController) Runner { return &exportImages{name: "导出镜像", p: p} })
+}
+
+// newRunners 按照配置的顺序创建执行步骤，同步镜像前必须先解析镜像，校验和上报镜像结果前必须先同步或者导出镜像
+func newRunners(p *PluginController, stages []string) ([]Runner, error) {
+	if len(stages) == 0 {
+		stages = DefaultStages
+	}
+
+	var runners []Runner
+	seen := sets.NewString()
+	for _, stage := range stages {
+		factory, ok := runnerFactories[stage]
+		if !ok {
+			return nil, fmt.Errorf("unknown plugin stage %s, available stages: %s", stage, strings.Join(sets.StringKeySet(runnerFactories).List(), ", "))
+		}
+		if seen.Has(stage) {
+			return nil, fmt.Errorf("duplicate plugin stage %s", stage)
+		}
+		if (stage == CopyStage || stage == ExportStage) && !seen.Has(ResolveStage) {
+			return nil, fmt.Errorf("plugin stage %s must run after %s", stage, ResolveStage)
+		}
+		if stage == VerifyStage && !seen.Has(CopyStage) {
+			return nil, fmt.Errorf("plugin stage %s must run after %s", stage, CopyStage)
+		}
+		if stage == ReportStage && !seen.HasAny(CopyStage, ExportStage) {
+			return nil, fmt.Errorf("plugin stage %s must run after %s or %s", stage, CopyStage, ExportStage)
+		}
+		// 先上报的镜像结果不会再被校验更新
+		if stage == VerifyStage && seen.Has(ReportStage) {
+			return nil, fmt.Errorf("plugin stage %s must run before %s", stage, ReportStage)
+		}
+		if stage == ExportStage && len(p.Cfg.Plugin.Bundle) == 0 {
+			return nil, fmt.Errorf("plugin stage %s requires plugin.bundle", ExportStage)
+		}
+		seen.Insert(stage)
+		runners = append(runners, factory(p))
+	}
+	return runners, nil
+}
+
+func newImageHooks(p *PluginController, names []string) ([]ImageHook, error) {
+	var hooks []ImageHook
+	seen := sets.NewString()
+	for _, name := range names {
+		factory, ok := imageHookFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown image hook %s, available hooks: %s", name, strings.Join(sets.StringKeySet(imageHookFactories).List(), ", "))
+		}
+		if seen.Has(name) {
+			return nil, fmt.Errorf("duplicate image hook %s", name)
+		}
+		seen.Insert(name)
+
+		hook, err := factory(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create image hook %s: %v", name, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// preflight 检查目标仓库是否可以访问以及认证信息是否有效
+type preflight struct {
+	name string
+	p    *PluginController
+}
+
+func (pf *preflight) GetName() string {
+	return pf.name
+}
+
+func (pf *preflight) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	return pf.p.registryClient.Ping(ctx, registryHost(pf.p.Registry))
+}
+
+type login struct {
+	name string
+	p    *PluginController
+}
+
+func (l *login) GetName() string {
+	return l.name
+}
+
+// Run registry 驱动在复制时直接使用仓库的认证信息，无需提前登陆。
+// docker 驱动通过 API 校验认证信息，不执行 docker login，密码不会出现在命令行参数中
+func (l *login) Run() error {
+	loginer, ok := l.p.syncer.(SyncerLoginer)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	return loginer.Login(ctx)
+}
+
+type image struct {
+	name string
+	p    *PluginController
+}
+
+func (i *image) GetName() string {
+	return i.name
+}
+
+func (i *image) Run() error {
+	var images []string
+	if i.p.Cfg.Default.PushKubernetes {
+		kubeImages, err := i.p.getKubernetesImages()
+		if err != nil {
+			return fmt.Errorf("获取 k8s 镜像失败: %v", err)
+		}
+		images = append(images, kubeImages...)
+	}
+
+	if i.p.Cfg.Default.PushImages {
+		fileImages, err := i.p.getImagesFromFile()
+		if err != nil {
+			return err
+		}
+		images = append(images, fileImages...)
+	}
+
+	sourceImages, err := i.p.getImagesFromSources()
+	if err != nil {
+		return err
+	}
+	images = append(images, sourceImages...)
+
+	// 例如 nginx:1.25.*，通过源仓库的 tags API 展开为具体的镜像
+	ctx, cancel := context.WithTimeout(context.Background(), expandTagsTimeout)
+	defer cancel()
+	images, err = i.p.registryClient.ExpandTagPatterns(ctx, images, i.p.Cfg.Default.MaxTags)
+	if err != nil {
+		return fmt.Errorf("展开镜像 tag 失败: %v", err)
+	}
+
+	// 重复的镜像只同步一次
+	i.p.Images = sets.NewString().Insert(images...).List()
+	return nil
+}
+
+// copyImages 同步所有镜像，单个镜像失败不影响其他镜像，结果由之后的 verify 和 report 步骤处理
+type copyImages struct {
+	name string
+	p    *PluginController
+}
+
+func (c *copyImages) GetName() string {
+	return c.name
+}
+
+func (c *copyImages) Run() error {
+	c.p.imageResults = c.p.syncImages()
+	return nil
+}
+
+// verifyImages 校验推送的镜像，目标镜像的 digest 和推送的 digest 不一致时该镜像同步失败
+type verifyImages struct {
+	name string
+	p    *PluginController
+}
+
+func (v *verifyImages) GetName() string {
+	return v.name
+}
+
+func (v *verifyImages) Run() error {
+	for i, r := range v.p.imageResults {
+		// 跳过的镜像在同步前已经比较过目标镜像的 digest
+		if r.err != nil || r.skipped {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+		v.p.imageResults[i].err = v.p.verifyImage(ctx, r.name, r.digest.target)
+		cancel()
+	}
+	return nil
+}
+
+// report 上报每个镜像的最终状态，未配置时只上报任务的汇总结果
+type report struct {
+	name string
+	p    *PluginController
+}
+
+func (r *report) GetName() string {
+	return r.name
+}
+
+func (r *report) Run() error {
+	for _, result := range r.p.imageResults {
+		r.p.reportImageResult(result)
+	}
+	return nil
+}
+
+// cleanup 删除同步过程中产生的本地数据，例如 docker 驱动拉取的镜像
+type cleanup struct {
+	name string
+	p    *PluginController
+}
+
+func (c *cleanup) GetName() string {
+	return c.name
+}
+
+func (c *cleanup) Run() error {
+	cleaner, ok := c.p.syncer.(SyncerCleaner)
+	if !ok {
+		return nil
+	}
+	if err := cleaner.Cleanup(context.Background()); err != nil {
+		// 清理失败不影响同步结果
+		klog.Warningf("failed to cleanup %s syncer: %v", c.p.syncer.Name(), err)
+	}
+	return nil
+}
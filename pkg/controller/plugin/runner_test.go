@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
+const denyLatestHook = "deny-latest"
+
+// denyLatest 模拟团队自定义的策略检查，拒绝同步 latest 镜像
+type denyLatest struct {
+	lock   sync.Mutex
+	synced []string
+}
+
+func (d *denyLatest) GetName() string { return denyLatestHook }
+
+func (d *denyLatest) PreSync(ctx context.Context, source string, target string) error {
+	if strings.HasSuffix(source, ":latest") {
+		return fmt.Errorf("latest tag is not allowed")
+	}
+	return nil
+}
+
+func (d *denyLatest) PostSync(ctx context.Context, source string, target string, digest string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.synced = append(d.synced, source)
+	return nil
+}
+
+var testDenyLatest = &denyLatest{}
+
+func init() {
+	RegisterImageHook(denyLatestHook, func(p *PluginController) (ImageHook, error) { return testDenyLatest, nil })
+}
+
+// statusRecorder 记录插件上报的镜像最终状态
+type statusRecorder struct {
+	lock   sync.Mutex
+	images map[string]string
+}
+
+func (r *statusRecorder) Post(url string, val interface{}, data map[string]interface{}) error {
+	return nil
+}
+
+func (r *statusRecorder) Put(url string, val interface{}, data map[string]interface{}) error {
+	if !strings.HasSuffix(url, "/rainbow/images/status") || data["status"] == model.ImageRunningStatus {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.images[data["name"].(string)] = data["status"].(string)
+	return nil
+}
+
+func (r *statusRecorder) Get(url string, val interface{}) error { return nil }
+
+func runnerNames(runners []Runner) []string {
+	var names []string
+	for _, r := range runners {
+		names = append(names, r.GetName())
+	}
+	return names
+}
+
+func TestNewRunners(t *testing.T) {
+	tests := []struct {
+		name    string
+		stages  []string
+		want    int
+		wantErr string
+	}{
+		{name: "default", want: len(DefaultStages)},
+		{name: "without verify", stages: []string{ResolveStage, CopyStage, ReportStage}, want: 3},
+		{name: "export", stages: []string{ResolveStage, ExportStage, ReportStage}, want: 3},
+		{name: "unknown", stages: []string{"scan"}, wantErr: "unknown plugin stage"},
+		{name: "duplicate", stages: []string{ResolveStage, ResolveStage}, wantErr: "duplicate"},
+		{name: "copy before resolve", stages: []string{CopyStage, ResolveStage}, wantErr: "must run after resolve"},
+		{name: "verify without copy", stages: []string{ResolveStage, VerifyStage}, wantErr: "must run after copy"},
+		{name: "report without images", stages: []string{ResolveStage, ReportStage}, wantErr: "must run after copy or export"},
+		{name: "verify after report", stages: []string{ResolveStage, CopyStage, ReportStage, VerifyStage}, wantErr: "must run before report"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &PluginController{}
+			p.Cfg.Plugin.Bundle = "images.tar"
+			runners, err := newRunners(p, tc.stages)
+			if len(tc.wantErr) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("newRunners() error = %v, want %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newRunners() error = %v", err)
+			}
+			if len(runners) != tc.want {
+				t.Errorf("newRunners() = %v", runnerNames(runners))
+			}
+		})
+	}
+}
+
+// newTestPipeline 源镜像 library/nginx 的 1.25 和 latest 都存在，syncer 推送 pushed
+func newTestPipeline(t *testing.T, stages []string, hooks []string, syncer *platformSyncer) (*PluginController, *statusRecorder) {
+	srv, host := newManifestServer(t)
+	for _, tag := range []string{"1.25", "latest"} {
+		blob := []byte(tag)
+		srv.add("library/nginx:"+tag, newManifest(t, registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest,
+			Config: registry.Descriptor{Digest: registry.Digest(blob), Size: int64(len(blob))}}, registry.MediaTypeOCIManifest))
+	}
+
+	var cfg config.Config
+	cfg.Default.PushImages = true
+	cfg.Images = []string{host + "/library/nginx:1.25", host + "/library/nginx:latest"}
+	cfg.Plugin.Synced = true
+	p := NewPluginController(cfg)
+	recorder := &statusRecorder{images: make(map[string]string)}
+	p.httpClient = recorder
+	p.Registry = config.Registry{Repository: host, Namespace: "mirror"}
+	p.registryClient = registry.NewClient()
+	syncer.client = p.registryClient
+	p.syncer = syncer
+
+	var err error
+	if p.Runners, err = newRunners(p, stages); err != nil {
+		t.Fatal(err)
+	}
+	if p.imageHooks, err = newImageHooks(p, hooks); err != nil {
+		t.Fatal(err)
+	}
+	return p, recorder
+}
+
+func TestImageHookPolicyCheck(t *testing.T) {
+	pushed := newManifest(t, registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}, registry.MediaTypeOCIManifest)
+	syncer := &platformSyncer{pushed: pushed}
+	p, recorder := newTestPipeline(t, []string{ResolveStage, CopyStage, VerifyStage, ReportStage}, []string{denyLatestHook}, syncer)
+
+	result, err := p.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result != (TaskResult{Succeeded: 1, Failed: 1}) {
+		t.Errorf("Run() = %+v", result)
+	}
+	// 被策略拒绝的镜像不会同步
+	if syncer.syncs != 1 {
+		t.Errorf("synced %d images, want 1", syncer.syncs)
+	}
+	if len(testDenyLatest.synced) != 1 || !strings.HasSuffix(testDenyLatest.synced[0], ":1.25") {
+		t.Errorf("PostSync called for %v", testDenyLatest.synced)
+	}
+
+	want := map[string]string{
+		p.Images[0]: model.ImageSucceededStatus,
+		p.Images[1]: model.ImageFailedStatus,
+	}
+	for image, status := range want {
+		if recorder.images[image] != status {
+			t.Errorf("reported status of %s = %q, want %q", image, recorder.images[image], status)
+		}
+	}
+}
+
+func TestVerifyAndReportStages(t *testing.T) {
+	pushed := newManifest(t, registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}, registry.MediaTypeOCIManifest)
+
+	tests := []struct {
+		name   string
+		stages []string
+		// syncer 返回的 digest 和实际推送的 manifest 不一致
+		mismatch     bool
+		want         TaskResult
+		wantReported string
+	}{
+		{
+			name:         "verified",
+			stages:       []string{ResolveStage, CopyStage, VerifyStage, ReportStage},
+			want:         TaskResult{Succeeded: 2},
+			wantReported: model.ImageSucceededStatus,
+		},
+		{
+			name:         "digest mismatch",
+			stages:       []string{ResolveStage, CopyStage, VerifyStage, ReportStage},
+			mismatch:     true,
+			want:         TaskResult{Failed: 2},
+			wantReported: model.ImageFailedStatus,
+		},
+		{
+			name:         "verify disabled",
+			stages:       []string{ResolveStage, CopyStage, ReportStage},
+			mismatch:     true,
+			want:         TaskResult{Succeeded: 2},
+			wantReported: model.ImageSucceededStatus,
+		},
+		// 未配置 report 时只上报任务的汇总结果
+		{
+			name:   "report disabled",
+			stages: []string{ResolveStage, CopyStage, VerifyStage},
+			want:   TaskResult{Succeeded: 2},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			syncer := &platformSyncer{pushed: pushed}
+			if tc.mismatch {
+				syncer.reported = registry.Digest([]byte("other"))
+			}
+			p, recorder := newTestPipeline(t, tc.stages, nil, syncer)
+
+			result, err := p.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if result != tc.want {
+				t.Errorf("Run() = %+v, want %+v", result, tc.want)
+			}
+			for _, image := range p.Images {
+				if recorder.images[image] != tc.wantReported {
+					t.Errorf("reported status of %s = %q, want %q", image, recorder.images[image], tc.wantReported)
+				}
+			}
+		})
+	}
+}
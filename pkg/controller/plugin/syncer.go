@@ -0,0 +1,352 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
+const (
+	// RegistryDriver 通过 registry API 直接复制镜像，无需 docker daemon
+	RegistryDriver = "registry"
+	// DockerDriver 通过 docker pull / tag / push 同步镜像
+	DockerDriver = "docker"
+)
+
+// ImageSyncer 将源镜像同步到目标镜像，返回推送到目标仓库的 manifest digest，progress 可以为空
+type ImageSyncer interface {
+	Name() string
+	// TargetDigest 返回同步 source 时将要推送到目标仓库的 manifest digest，用于判断目标镜像是否已是最新
+	TargetDigest(ctx context.Context, source registry.Reference) (string, error)
+	Sync(ctx context.Context, source string, target string, progress ProgressFunc) (string, error)
+	Close()
+}
+
+// SyncerCleaner 同步过程中会在本地留下数据的 ImageSyncer 需要实现，由 cleanup 步骤调用
+type SyncerCleaner interface {
+	Cleanup(ctx context.Context) error
+}
+
+// SyncerLoginer 需要提前校验认证信息的 ImageSyncer 需要实现，由 login 步骤调用
+type SyncerLoginer interface {
+	Login(ctx context.Context) error
+}
+
+// NewImageSyncer platforms 为空时同步镜像的所有平台
+func NewImageSyncer(driver string, client *registry.Client, reg config.Registry, cred registry.Credential, platforms []registry.Platform) (ImageSyncer, error) {
+	switch driver {
+	case RegistryDriver, "":
+		return &registrySyncer{client: client, platforms: platforms}, nil
+	case DockerDriver:
+		// docker pull 只能拉取单个平台的镜像
+		if len(platforms) > 1 {
+			return nil, fmt.Errorf("%s driver supports only one platform", DockerDriver)
+		}
+		return newDockerSyncer(client, reg, cred, platforms)
+	}
+	return nil, fmt.Errorf("unsupported plugin driver %s", driver)
+}
+
+type registrySyncer struct {
+	client    *registry.Client
+	platforms []registry.Platform
+}
+
+// NewRegistryClient 使用目标仓库的认证信息创建 registry 客户端，源镜像匿名拉取
+func NewRegistryClient(reg config.Registry, cred registry.Credential) *registry.Client {
+	host := registryHost(reg)
+
+	opts := []registry.Option{registry.WithAuth(host, cred)}
+	if reg.Insecure {
+		opts = append(opts, registry.WithInsecure(host))
+	}
+	return registry.NewClient(opts...)
+}
+
+func (r *registrySyncer) Name() string { return RegistryDriver }
+
+// TargetDigest 过滤平台时为过滤后 index 的 digest，否则为源镜像的 digest
+func (r *registrySyncer) TargetDigest(ctx context.Context, source registry.Reference) (string, error) {
+	return r.client.TargetDigest(ctx, source, r.platforms)
+}
+
+func (r *registrySyncer) Sync(ctx context.Context, source string, target string, progress ProgressFunc) (string, error) {
+	src, err := registry.ParseReference(source)
+	if err != nil {
+		return "", err
+	}
+	dst, err := registry.ParseReference(target)
+	if err != nil {
+		return "", err
+	}
+
+	klog.Infof("starting copy image %s to %s", src, dst)
+	opts := registry.CopyOptions{Platforms: r.platforms}
+	if progress != nil {
+		opts.Progress = func(p registry.Progress) {
+			progress(Progress{Phase: CopyPhase, Current: p.Current, Total: p.Total})
+		}
+	}
+	digest, err := r.client.Copy(ctx, src, dst, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy image %s to %s: %w", source, target, err)
+	}
+	klog.Infof("complete copy image %s", target)
+	return digest, nil
+}
+
+func (r *registrySyncer) Close() {}
+
+type dockerSyncer struct {
+	docker *client.Client
+	// 用于解析源镜像中对应平台的 manifest digest
+	client *registry.Client
+	// 推送目标镜像时使用的认证信息，通过 API 传递给 docker，不会写入节点的 ~/.docker/config.json
+	auth         types.AuthConfig
+	registryAuth string
+	// 为空时使用 docker 所在节点的平台
+	platform string
+
+	// 拉取和打标签产生的本地镜像，清理时删除
+	lock   sync.Mutex
+	images []string
+}
+
+func newDockerSyncer(regClient *registry.Client, reg config.Registry, cred registry.Credential, platforms []registry.Platform) (*dockerSyncer, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	// 检查 docker 的客户端是否正常
+	if _, err = cli.Ping(context.Background()); err != nil {
+		_ = cli.Close()
+		return nil, err
+	}
+	d := &dockerSyncer{docker: cli, client: regClient}
+	if len(platforms) != 0 {
+		d.platform = platforms[0].String()
+	}
+	if !cred.IsEmpty() {
+		d.auth = types.AuthConfig{
+			Username:      cred.Username,
+			Password:      cred.Password,
+			IdentityToken: cred.IdentityToken,
+			RegistryToken: cred.RegistryToken,
+			ServerAddress: registryHost(reg),
+		}
+		if d.registryAuth, err = encodeRegistryAuth(d.auth); err != nil {
+			_ = cli.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// encodeRegistryAuth docker API 要求认证信息为 base64url 编码的 json
+func encodeRegistryAuth(auth types.AuthConfig) (string, error) {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// Login 通过 docker API 校验认证信息，和 docker login 不同，不会修改节点上的认证配置
+func (d *dockerSyncer) Login(ctx context.Context) error {
+	if len(d.registryAuth) == 0 {
+		return nil
+	}
+	if _, err := d.docker.RegistryLogin(ctx, d.auth); err != nil {
+		return fmt.Errorf("failed to login registry %s: %v", d.auth.ServerAddress, err)
+	}
+	return nil
+}
+
+func (d *dockerSyncer) Name() string { return DockerDriver }
+
+// TargetDigest docker 只推送单个平台的镜像，多平台镜像返回对应平台的 manifest digest
+func (d *dockerSyncer) TargetDigest(ctx context.Context, source registry.Reference) (string, error) {
+	platform, err := d.resolvePlatform(ctx)
+	if err != nil {
+		return "", err
+	}
+	return d.client.PlatformDigest(ctx, source, platform)
+}
+
+// resolvePlatform 未指定平台时 docker 拉取 daemon 所在节点平台的镜像
+func (d *dockerSyncer) resolvePlatform(ctx context.Context) (registry.Platform, error) {
+	if len(d.platform) != 0 {
+		return registry.ParsePlatform(d.platform)
+	}
+	version, err := d.docker.ServerVersion(ctx)
+	if err != nil {
+		return registry.Platform{}, fmt.Errorf("failed to get docker platform: %v", err)
+	}
+	return registry.Platform{OS: version.Os, Architecture: version.Arch}, nil
+}
+
+func (d *dockerSyncer) Sync(ctx context.Context, source string, target string, progress ProgressFunc) (string, error) {
+	klog.Infof("starting pull image %s", source)
+	reader, err := d.docker.ImagePull(ctx, source, types.ImagePullOptions{Platform: d.platform})
+	if err != nil {
+		klog.Errorf("failed to pull %s: %v", source, err)
+		return "", err
+	}
+	_, err = decodeDockerStream(reader, PullPhase, progress)
+	reader.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to pull image %s: %w", source, err)
+	}
+
+	d.addImages(source)
+
+	klog.Infof("tag %s to %s", source, target)
+	if err := d.docker.ImageTag(ctx, source, target); err != nil {
+		klog.Errorf("failed to tag %s to %s: %v", source, target, err)
+		return "", err
+	}
+	d.addImages(target)
+
+	klog.Infof("starting push image %s", target)
+	reader, err = d.docker.ImagePush(ctx, target, types.ImagePushOptions{RegistryAuth: d.registryAuth})
+	if err != nil {
+		return "", fmt.Errorf("failed to push image %s: %w", target, err)
+	}
+	defer reader.Close()
+
+	// docker 推送的是本地平台的镜像，digest 以推送结果为准
+	digest, err := decodeDockerStream(reader, PushPhase, progress)
+	if err != nil {
+		return "", fmt.Errorf("failed to push image %s: %w", target, err)
+	}
+	klog.Infof("complete push image %s", source)
+	return digest, nil
+}
+
+func (d *dockerSyncer) addImages(images ...string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.images = append(d.images, images...)
+}
+
+// Cleanup 删除同步过程中拉取的镜像，释放节点的磁盘空间
+func (d *dockerSyncer) Cleanup(ctx context.Context) error {
+	d.lock.Lock()
+	images := d.images
+	d.images = nil
+	d.lock.Unlock()
+
+	var errs []error
+	for _, image := range images {
+		if _, err := d.docker.ImageRemove(ctx, image, types.ImageRemoveOptions{}); err != nil && !client.IsErrNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (d *dockerSyncer) Close() {
+	_ = d.docker.Close()
+}
+
+// dockerMessage docker pull / push 返回的 json 消息
+type dockerMessage struct {
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	Progress *struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	ErrorMessage string `json:"error"`
+	// 推送完成后返回 tag, digest 和 size
+	Aux *struct {
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+type layerProgress struct {
+	current int64
+	total   int64
+}
+
+// decodeDockerStream 解析 docker 的 json 消息流，按镜像层汇总传输的字节数，返回推送结果中的 digest
+func decodeDockerStream(r io.Reader, phase string, progress ProgressFunc) (string, error) {
+	var (
+		digest string
+		layers = make(map[string]*layerProgress)
+		order  []string
+	)
+
+	report := func() {
+		if progress == nil {
+			return
+		}
+		p := Progress{Phase: phase}
+		for _, id := range order {
+			p.Current += layers[id].current
+			p.Total += layers[id].total
+		}
+		progress(p)
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
+		var msg dockerMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+		if msg.Error != nil {
+			return "", fmt.Errorf("%s", msg.Error.Message)
+		}
+		if len(msg.ErrorMessage) != 0 {
+			return "", fmt.Errorf("%s", msg.ErrorMessage)
+		}
+		if msg.Aux != nil && len(msg.Aux.Digest) != 0 {
+			digest = msg.Aux.Digest
+		}
+		if len(msg.ID) == 0 {
+			continue
+		}
+
+		layer, ok := layers[msg.ID]
+		switch msg.Status {
+		// 解压镜像层时也会返回 progressDetail，只统计传输的字节数
+		case "Downloading", "Pushing":
+			if msg.Progress == nil || msg.Progress.Total <= 0 {
+				continue
+			}
+			if !ok {
+				layer = &layerProgress{}
+				layers[msg.ID] = layer
+				order = append(order, msg.ID)
+			}
+			layer.current, layer.total = msg.Progress.Current, msg.Progress.Total
+		case "Download complete", "Pull complete", "Pushed", "Layer already exists", "Already exists":
+			if !ok {
+				continue
+			}
+			layer.current = layer.total
+		default:
+			continue
+		}
+		report()
+	}
+	return digest, nil
+}
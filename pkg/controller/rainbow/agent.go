@@ -3,9 +3,11 @@ package rainbow
 import (
 	"context"
 	"fmt"
-	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -14,11 +16,19 @@ import (
 
 	"github.com/caoyingjunz/pixiulib/strutil"
 	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
-	"github.com/caoyingjunz/rainbow/pkg/db"
+	"github.com/caoyingjunz/rainbow/pkg/controller/dispatcher"
+	"github.com/caoyingjunz/rainbow/pkg/controller/workspace"
 	"github.com/caoyingjunz/rainbow/pkg/db/model"
-	"github.com/caoyingjunz/rainbow/pkg/template"
-	"github.com/caoyingjunz/rainbow/pkg/util"
-	"github.com/caoyingjunz/rainbow/pkg/util/errors"
+)
+
+const (
+	// 长轮询获取任务时 server 端的最长等待时间
+	watchTimeout = 30 * time.Second
+
+	// 同步运行状态、日志和处理取消请求的间隔
+	runSyncInterval = 15 * time.Second
+	// 上报给 server 的日志尾部的最大长度
+	maxRunLogsSize = 60 * 1024
 )
 
 type AgentGetter interface {
@@ -29,41 +39,77 @@ type Interface interface {
 }
 
 type AgentController struct {
-	factory db.ShareDaoFactory
-	cfg     rainbowconfig.Config
+	client AgentClient
+	cfg    rainbowconfig.Config
 
 	queue workqueue.RateLimitingInterface
 
-	name     string
-	callback string
-	baseDir  string
+	name       string
+	callback   string
+	baseDir    string
+	maxRetries int
+
+	dispatcher dispatcher.Dispatcher
+
+	// 最近一次上报的日志长度，日志没有变化时不重复上报
+	lock     sync.Mutex
+	logSizes map[string]int
 }
 
-func NewAgent(f db.ShareDaoFactory, cfg rainbowconfig.Config) *AgentController {
+func NewAgent(client AgentClient, cfg rainbowconfig.Config) *AgentController {
 	return &AgentController{
-		factory:  f,
-		cfg:      cfg,
-		name:     cfg.Agent.Name,
-		baseDir:  cfg.Agent.DataDir,
-		callback: cfg.Plugin.Callback,
-		queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "rainbow-agent"),
+		client:     client,
+		cfg:        cfg,
+		name:       cfg.Agent.Name,
+		baseDir:    cfg.Agent.DataDir,
+		callback:   cfg.Plugin.Callback,
+		maxRetries: cfg.Agent.MaxRetries,
+		logSizes:   make(map[string]int),
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "rainbow-agent"),
 	}
 }
 
+// Run 启动 agent 并阻塞直到 ctx 被取消，退出前等待处理中的任务完成并将 agent 标记为离线
 func (s *AgentController) Run(ctx context.Context, workers int) error {
+	var err error
+	ws := workspace.New(s.baseDir, s.cfg.Agent.Workspace)
+	if s.dispatcher, err = dispatcher.New(s.cfg.Agent, ws); err != nil {
+		return err
+	}
+
 	// 注册 rainbow 代理
-	if err := s.RegisterAgentIfNotExist(ctx); err != nil {
+	if err = s.RegisterAgent(); err != nil {
 		return err
 	}
 
 	go s.report(ctx)
 
+	go wait.UntilWithContext(ctx, s.syncRuns, runSyncInterval)
+
 	go s.getNextWorkItems(ctx)
 
+	go ws.Run(ctx)
+
+	// 处理中的任务不随 ctx 的取消而中断
+	workCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
-		go wait.UntilWithContext(ctx, s.worker, 1*time.Second)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.UntilWithContext(workCtx, s.worker, 1*time.Second)
+		}()
 	}
 
+	<-ctx.Done()
+	klog.Infof("shutting down rainbow agent %s, waiting for in-flight tasks", s.name)
+	s.queue.ShutDownWithDrain()
+	cancel()
+	wg.Wait()
+
+	s.markOffline()
 	return nil
 }
 
@@ -71,35 +117,141 @@ func (s *AgentController) report(ctx context.Context) {
 	ticker := time.NewTicker(60 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		newAgent, err := s.factory.Agent().GetByName(ctx, s.name)
-		if err != nil {
-			klog.Error("failed to get agent status %v", err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.client.Heartbeat(s.name); err != nil {
+			klog.Errorf("failed to sync agent status %v", err)
+		}
+	}
+}
+
+// syncRuns 同步 agent 上尚未结束的运行，处理用户的取消请求并上报运行状态和日志
+func (s *AgentController) syncRuns(ctx context.Context) {
+	runs, err := s.client.ListRuns(s.name)
+	if err != nil {
+		klog.Errorf("failed to list runs of agent %s: %v", s.name, err)
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, task := range runs {
+		// 切换执行模式后之前的运行无法由当前的后端处理
+		if task.Dispatcher != s.dispatcher.Name() {
 			continue
 		}
+		active[task.DispatchHandle] = true
+		s.syncRun(ctx, task)
+	}
 
-		updates := map[string]interface{}{"last_transition_time": time.Now()}
-		if newAgent.Status == model.UnknownAgentType {
-			updates["status"] = model.RunAgentType
-			updates["message"] = "Agent started posting status"
+	s.lock.Lock()
+	for handle := range s.logSizes {
+		if !active[handle] {
+			delete(s.logSizes, handle)
 		}
+	}
+	s.lock.Unlock()
+}
 
-		err = s.factory.Agent().UpdateByName(ctx, s.name, updates)
+func (s *AgentController) syncRun(ctx context.Context, task model.Task) {
+	handle := task.DispatchHandle
+	updates := make(map[string]interface{})
+
+	if task.CancelRequested {
+		updates["cancel_handled"] = true
+		if err := s.dispatcher.Cancel(ctx, handle); err != nil {
+			klog.Errorf("failed to cancel run %s of task %d: %v", handle, task.Id, err)
+			updates["message"] = fmt.Sprintf("取消失败: %v", err)
+		} else {
+			klog.Infof("run %s of task %d canceled", handle, task.Id)
+			updates["status"] = model.TaskCanceledStatus
+			updates["message"] = "任务已取消"
+			updates["run_status"] = dispatcher.CanceledStatus
+		}
+	} else {
+		status, err := s.dispatcher.Status(ctx, handle)
 		if err != nil {
-			klog.Error("failed to sync agent status %v", err)
+			klog.Errorf("failed to get status of run %s of task %d: %v", handle, task.Id, err)
+		} else if status != task.RunStatus {
+			updates["run_status"] = status
+			// 插件在上报任务结果之前退出，任务不会再有回调
+			switch status {
+			case dispatcher.FailedStatus:
+				updates["status"] = model.TaskRunFailedStatus
+				updates["message"] = "插件运行失败"
+			case dispatcher.PartialFailedStatus:
+				updates["status"] = model.TaskPartialFailedStatus
+				updates["message"] = "部分镜像同步失败"
+			}
+		}
+	}
+
+	if logs, ok := s.runLogs(ctx, handle); ok {
+		updates["run_logs"] = logs
+	}
+	if len(updates) == 0 {
+		return
+	}
+	if err := s.client.ReportTask(s.name, task.Id, updates); err != nil {
+		klog.Errorf("failed to report run %s of task %d: %v", handle, task.Id, err)
+	}
+}
+
+// runLogs 获取运行日志的尾部，日志没有变化或者后端不支持时返回 false
+func (s *AgentController) runLogs(ctx context.Context, handle string) (string, bool) {
+	logs, err := s.dispatcher.Logs(ctx, handle)
+	if err != nil {
+		klog.V(4).Infof("failed to get logs of run %s: %v", handle, err)
+		return "", false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(logs) == 0 || s.logSizes[handle] == len(logs) {
+		return "", false
+	}
+	s.logSizes[handle] = len(logs)
+	return tailLogs(logs, maxRunLogsSize), true
+}
+
+// tailLogs 截取日志的最后 size 个字节，不截断多字节字符
+func tailLogs(logs string, size int) string {
+	if len(logs) <= size {
+		return logs
+	}
+	logs = logs[len(logs)-size:]
+	for i := 0; i < len(logs) && i < utf8.UTFMax; i++ {
+		if utf8.RuneStart(logs[i]) {
+			return logs[i:]
 		}
 	}
+	return logs
+}
+
+// markOffline 退出时将 agent 标记为离线，使 server 无需等待失联超时
+func (s *AgentController) markOffline() {
+	if err := s.client.Offline(s.name); err != nil {
+		klog.Errorf("failed to mark agent %s offline: %v", s.name, err)
+	}
 }
 
 func (s *AgentController) getNextWorkItems(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
 
-	for range ticker.C {
-		// 获取未处理
-		tasks, err := s.factory.Task().ListWithAgent(ctx, s.name, 0)
+		// 通过 server 的长轮询接口获取未处理的任务，失败时等待后重试
+		tasks, err := s.client.WatchTasks(s.name, watchTimeout)
 		if err != nil {
-			klog.Error("failed to list tasks %v", err)
+			klog.Errorf("failed to watch tasks from server: %v", err)
+			s.sleep(ctx, 5*time.Second)
 			continue
 		}
 		if len(tasks) == 0 {
@@ -109,6 +261,15 @@ func (s *AgentController) getNextWorkItems(ctx context.Context) {
 		for _, task := range tasks {
 			s.queue.Add(fmt.Sprintf("%d/%d", task.Id, task.ResourceVersion))
 		}
+		// 任务被认领前仍会被返回，避免频繁请求
+		s.sleep(ctx, 1*time.Second)
+	}
+}
+
+func (s *AgentController) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
 	}
 }
 
@@ -124,6 +285,11 @@ func (s *AgentController) processNextWorkItem(ctx context.Context) bool {
 	}
 	defer s.queue.Done(key)
 
+	// 退出过程中不再处理新的任务，未认领的任务会重新被调度
+	if s.queue.ShuttingDown() {
+		return false
+	}
+
 	taskId, resourceVersion, err := KeyFunc(key)
 	if err != nil {
 		s.handleErr(ctx, err, key)
@@ -133,101 +299,92 @@ func (s *AgentController) processNextWorkItem(ctx context.Context) bool {
 	return true
 }
 
-func (s *AgentController) makePluginConfig(ctx context.Context, task model.Task) (*template.PluginTemplateConfig, error) {
-	taskId := task.Id
-
-	registry, err := s.factory.Registry().Get(ctx, task.RegisterId)
+func (s *AgentController) sync(ctx context.Context, taskId int64, resourceVersion int64) error {
+	// 任务已被其他 agent 认领或者已被更新时，server 返回空任务
+	task, err := s.client.ClaimTask(s.name, taskId, resourceVersion)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get registry %v", err)
+		return fmt.Errorf("failed to claim task %d: %v", taskId, err)
+	}
+	if task == nil {
+		return nil
 	}
-	images, err := s.factory.Image().ListWithTask(ctx, taskId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get images %v", err)
-	}
-	var img []string
-	for _, image := range images {
-		img = append(img, image.Name)
-	}
-
-	return &template.PluginTemplateConfig{
-		Default: template.DefaultOption{
-			PushImages: true,
-		},
-		Plugin: template.PluginOption{
-			Callback: s.callback,
-			TaskId:   taskId,
-			Synced:   true,
-		},
-		Registry: template.Registry{
-			Repository: registry.Repository,
-			Namespace:  registry.Namespace,
-			Username:   registry.Username,
-			Password:   registry.Password,
-		},
-		Images: img,
-	}, nil
-}
 
-func (s *AgentController) sync(ctx context.Context, taskId int64, resourceVersion int64) error {
-	task, err := s.factory.Task().GetOne(ctx, taskId, resourceVersion)
+	tplCfg, err := s.client.GetPluginConfig(s.name, taskId)
 	if err != nil {
-		if errors.IsNotUpdated(err) {
-			return nil
-		}
-		return fmt.Errorf("failted to get one task %d %v", taskId, err)
+		return fmt.Errorf("failed to get plugin config of task %d: %v", taskId, err)
 	}
-
-	tplCfg, err := s.makePluginConfig(ctx, *task)
+	tplCfg.Plugin.Callback = s.callback
+	// 插件的执行参数取决于 agent 所在的环境
+	plugin := s.cfg.Plugin
+	tplCfg.Plugin.Driver = plugin.Driver
+	tplCfg.Plugin.Concurrency = plugin.Concurrency
+	tplCfg.Plugin.MaxRetries = plugin.MaxRetries
+	tplCfg.Plugin.RetryBackoff = plugin.RetryBackoff
+	tplCfg.Plugin.ImageTimeout = plugin.ImageTimeout
+	tplCfg.Plugin.ProgressInterval = plugin.ProgressInterval
+	tplCfg.Plugin.Stages = plugin.Stages
+	tplCfg.Plugin.ImageHooks = plugin.ImageHooks
 	cfg, err := yaml.Marshal(tplCfg)
 	if err != nil {
 		return err
 	}
 
-	taskIdStr := fmt.Sprintf("%d", taskId)
-
-	destDir := filepath.Join(s.baseDir, taskIdStr)
-	if err = util.EnsureDirectoryExists(destDir); err != nil {
-		return err
+	handle, err := s.dispatcher.Dispatch(ctx, *task, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to dispatch task %d by %s: %v", taskId, s.dispatcher.Name(), err)
 	}
-	if !util.IsDirectoryExists(destDir + "/plugin") {
-		if err = util.Copy(s.baseDir+"/plugin", destDir); err != nil {
-			return err
-		}
+	if err = s.client.ReportTask(s.name, taskId, map[string]interface{}{
+		"dispatcher":      s.dispatcher.Name(),
+		"dispatch_handle": handle,
+	}); err != nil {
+		klog.Errorf("failed to record dispatch handle %s of task %d: %v", handle, taskId, err)
 	}
 
-	git := util.NewGit(destDir+"/plugin", taskIdStr, taskIdStr+"-"+time.Now().String())
-	if err = git.Checkout(); err != nil {
-		return err
-	}
-	if err = util.WriteIntoFile(string(cfg), destDir+"/plugin/config.yaml"); err != nil {
-		return err
-	}
-	if err = git.Push(); err != nil {
-		return err
-	}
-	return nil
+	return s.dispatcher.Wait(ctx, handle)
 }
 
-// TODO
 func (s *AgentController) handleErr(ctx context.Context, err error, key interface{}) {
 	if err == nil {
+		s.queue.Forget(key)
+		return
+	}
+
+	taskId, _, keyErr := KeyFunc(key)
+	if keyErr != nil {
+		klog.Errorf("dropping invalid key %v: %v", key, keyErr)
+		s.queue.Forget(key)
+		return
+	}
+
+	if s.queue.NumRequeues(key) < s.maxRetries {
+		klog.Errorf("failed to sync task %v, retrying: %v", key, err)
+		s.queue.AddRateLimited(key)
 		return
 	}
-	klog.Error(err)
+
+	klog.Errorf("dropping task %v out of the queue after %d retries: %v", key, s.maxRetries, err)
+	s.queue.Forget(key)
+
+	if err = s.client.ReportTask(s.name, taskId, map[string]interface{}{
+		"status":  model.TaskRunFailedStatus,
+		"message": err.Error(),
+	}); err != nil {
+		klog.Errorf("failed to mark task %d failed: %v", taskId, err)
+	}
 }
 
-func (s *AgentController) RegisterAgentIfNotExist(ctx context.Context) error {
+// RegisterAgent 向 server 注册 agent 及其调度属性
+func (s *AgentController) RegisterAgent() error {
 	if len(s.name) == 0 {
 		return fmt.Errorf("agent name missing")
 	}
 
-	var err error
-	_, err = s.factory.Agent().GetByName(ctx, s.name)
-	if err == nil {
-		return nil
+	opt := s.cfg.Agent
+	arch := opt.Arch
+	if len(arch) == 0 {
+		arch = runtime.GOARCH
 	}
-	_, err = s.factory.Agent().Create(ctx, &model.Agent{Name: s.name, Status: model.RunAgentType, Type: model.PublicAgentType, Message: "Agent started posting status"})
-	return err
+	return s.client.Register(s.name, opt.Labels, arch, opt.MaxConcurrentTasks)
 }
 
 func KeyFunc(key interface{}) (int64, int64, error) {
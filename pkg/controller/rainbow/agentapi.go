@@ -0,0 +1,241 @@
+package rainbow
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+	"github.com/caoyingjunz/rainbow/pkg/template"
+	"github.com/caoyingjunz/rainbow/pkg/types"
+	"github.com/caoyingjunz/rainbow/pkg/util/errors"
+)
+
+// RegisterAgent 注册 agent，已注册的 agent 同步最新的调度属性
+func (s *ServerController) RegisterAgent(ctx context.Context, agentName string, req *types.RegisterAgentRequest) error {
+	// 内置的架构标签由 agent 上报的 arch 决定，避免和自定义标签冲突
+	if _, ok := req.Labels[ArchLabel]; ok {
+		return fmt.Errorf("label %s is reserved, set agent arch instead", ArchLabel)
+	}
+	agentLabels := labels.Set(req.Labels).String()
+
+	_, err := s.factory.Agent().GetByName(ctx, agentName)
+	if err == nil {
+		if err = s.factory.Agent().UpdateByName(ctx, agentName, map[string]interface{}{
+			"status":               model.RunAgentType,
+			"message":              "Agent started posting status",
+			"labels":               agentLabels,
+			"arch":                 req.Arch,
+			"max_concurrent_tasks": req.MaxConcurrentTasks,
+			"last_transition_time": time.Now(),
+		}); err != nil {
+			return err
+		}
+	} else {
+		if _, err = s.factory.Agent().Create(ctx, &model.Agent{
+			Name:               agentName,
+			Status:             model.RunAgentType,
+			Type:               model.PublicAgentType,
+			Message:            "Agent started posting status",
+			Labels:             agentLabels,
+			Arch:               req.Arch,
+			MaxConcurrentTasks: req.MaxConcurrentTasks,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// 新上线的 agent 可能满足之前无法调度的任务
+	s.notifier.Notify(schedulerKey)
+	return nil
+}
+
+// AgentHeartbeat 刷新 agent 的心跳时间
+func (s *ServerController) AgentHeartbeat(ctx context.Context, agentName string) error {
+	agent, err := s.factory.Agent().GetByName(ctx, agentName)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"last_transition_time": time.Now()}
+	if agent.Status != model.RunAgentType {
+		updates["status"] = model.RunAgentType
+		updates["message"] = "Agent started posting status"
+	}
+	return s.factory.Agent().UpdateByName(ctx, agentName, updates)
+}
+
+// AgentOffline agent 正常退出时调用，尚未认领的任务立即重新调度
+func (s *ServerController) AgentOffline(ctx context.Context, agentName string) error {
+	if err := s.factory.Agent().UpdateByName(ctx, agentName, map[string]interface{}{
+		"status":  model.UnRunAgentType,
+		"message": "Agent stopped gracefully",
+	}); err != nil {
+		return err
+	}
+
+	return s.requeueAgentTasks(ctx, agentName, false)
+}
+
+// ClaimTask agent 认领任务，任务已被其他 agent 认领或者已被更新时返回 nil
+func (s *ServerController) ClaimTask(ctx context.Context, agentName string, req *types.ClaimTaskRequest) (*model.Task, error) {
+	task, err := s.factory.Task().Get(ctx, req.TaskId)
+	if err != nil {
+		return nil, err
+	}
+	if task.AgentName != agentName {
+		return nil, nil
+	}
+
+	claimed, err := s.factory.Task().GetOne(ctx, req.TaskId, req.ResourceVersion)
+	if err == nil {
+		return claimed, nil
+	}
+	if !errors.IsNotUpdated(err) {
+		return nil, err
+	}
+
+	// 重试时任务已经在上一次处理中被当前 agent 认领
+	task, err = s.factory.Task().Get(ctx, req.TaskId)
+	if err != nil {
+		return nil, err
+	}
+	if task.AgentName == agentName && task.Process == 1 && task.ResourceVersion == req.ResourceVersion+1 {
+		return task, nil
+	}
+	return nil, nil
+}
+
+// GetPluginConfig 生成 agent 执行任务所需的插件配置，回调地址由 agent 填充
+func (s *ServerController) GetPluginConfig(ctx context.Context, agentName string, taskId int64) (*template.PluginTemplateConfig, error) {
+	task, err := s.factory.Task().Get(ctx, taskId)
+	if err != nil {
+		return nil, err
+	}
+	if task.AgentName != agentName {
+		return nil, fmt.Errorf("task %d is not assigned to agent %s", taskId, agentName)
+	}
+
+	reg, err := s.factory.Registry().Get(ctx, task.RegisterId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registry %v", err)
+	}
+	images, err := s.factory.Image().ListWithTask(ctx, taskId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get images %v", err)
+	}
+	var img []string
+	for _, image := range images {
+		img = append(img, image.Name)
+	}
+	taskNaming, err := registry.DecodeNamingPolicy(task.Naming)
+	if err != nil {
+		return nil, err
+	}
+	naming, err := effectiveNamingPolicy(reg.Naming, taskNaming)
+	if err != nil {
+		return nil, err
+	}
+	platforms, err := registry.ParsePlatforms(task.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	var platformNames []string
+	for _, platform := range platforms {
+		platformNames = append(platformNames, platform.String())
+	}
+	// 未配置 agent token 时 server 只能以 debug 模式运行，回调无需认证
+	var callbackToken string
+	if secret := s.cfg.Server.Auth.AgentToken; len(secret) != 0 {
+		callbackToken = CallbackToken(secret, taskId)
+	}
+
+	return &template.PluginTemplateConfig{
+		Default: template.DefaultOption{
+			PushImages: true,
+			Platforms:  platformNames,
+			Force:      task.Force,
+		},
+		Plugin: template.PluginOption{
+			TaskId:        taskId,
+			Synced:        true,
+			CallbackToken: callbackToken,
+		},
+		Registry: template.Registry{
+			Repository: reg.Repository,
+			Namespace:  reg.Namespace,
+			Username:   reg.Username,
+			Password:   reg.Password,
+			Token:      reg.Token,
+			Naming:     naming,
+		},
+		Images: img,
+	}, nil
+}
+
+// ReportTask 记录 agent 上报的任务分发结果
+func (s *ServerController) ReportTask(ctx context.Context, agentName string, req *types.ReportTaskRequest) error {
+	task, err := s.factory.Task().Get(ctx, req.TaskId)
+	if err != nil {
+		return err
+	}
+	if task.AgentName != agentName {
+		return fmt.Errorf("task %d is not assigned to agent %s", req.TaskId, agentName)
+	}
+
+	updates := make(map[string]interface{})
+	// 已结束的任务不再被运行状态覆盖，例如插件回调之后上报的运行失败
+	if !model.IsTaskTerminal(task.Status) {
+		if len(req.Status) != 0 {
+			updates["status"] = req.Status
+		}
+		if len(req.Message) != 0 {
+			updates["message"] = req.Message
+		}
+	}
+	if len(req.Dispatcher) != 0 {
+		updates["dispatcher"] = req.Dispatcher
+	}
+	if len(req.DispatchHandle) != 0 && req.DispatchHandle != task.DispatchHandle {
+		// 新的运行不保留上一次运行的状态和日志
+		updates["dispatch_handle"] = req.DispatchHandle
+		updates["run_status"] = ""
+		updates["run_logs"] = ""
+	}
+	if len(req.RunStatus) != 0 {
+		updates["run_status"] = req.RunStatus
+	}
+	if len(req.RunLogs) != 0 {
+		updates["run_logs"] = req.RunLogs
+	}
+	if req.CancelHandled {
+		updates["cancel_requested"] = false
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	klog.V(2).Infof("agent %s reported task %d: status=%v run_status=%v", agentName, req.TaskId, updates["status"], updates["run_status"])
+	return s.factory.Task().UpdateDirectly(ctx, req.TaskId, updates)
+}
+
+// ListAgentRuns 获取 agent 上已分发且尚未结束的运行，agent 据此同步运行状态和处理取消请求
+func (s *ServerController) ListAgentRuns(ctx context.Context, agentName string) ([]model.Task, error) {
+	tasks, err := s.factory.Task().ListActiveWithAgent(ctx, agentName)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []model.Task
+	for _, task := range tasks {
+		if len(task.DispatchHandle) == 0 {
+			continue
+		}
+		runs = append(runs, task)
+	}
+	return runs, nil
+}
@@ -0,0 +1,182 @@
+package rainbow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/template"
+	"github.com/caoyingjunz/rainbow/pkg/util"
+)
+
+const (
+	// AgentTokenHeader agent 访问 server 时携带 token 的请求头
+	AgentTokenHeader = "Authorization"
+	agentTokenPrefix = "Bearer "
+
+	clientTimeout = 10 * time.Second
+)
+
+// AgentClient agent 通过 server 的 API 完成注册、心跳和任务处理，不再直连数据库
+type AgentClient interface {
+	Register(name string, labels map[string]string, arch string, maxConcurrentTasks int) error
+	Heartbeat(name string) error
+	Offline(name string) error
+
+	WatchTasks(name string, timeout time.Duration) ([]model.Task, error)
+	ClaimTask(name string, taskId int64, resourceVersion int64) (*model.Task, error)
+	GetPluginConfig(name string, taskId int64) (*template.PluginTemplateConfig, error)
+	ReportTask(name string, taskId int64, updates map[string]interface{}) error
+	ListRuns(name string) ([]model.Task, error)
+}
+
+type agentClient struct {
+	server string
+
+	client util.HttpInterface
+	// 长轮询请求需要比 server 端的等待时间更长的超时
+	watchClient util.HttpInterface
+}
+
+func NewAgentClient(server string, token string) AgentClient {
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	server = strings.TrimSuffix(server, "/")
+
+	var header map[string]string
+	if len(token) != 0 {
+		header = map[string]string{AgentTokenHeader: agentTokenPrefix + token}
+	}
+	return &agentClient{
+		server:      server,
+		client:      util.NewHttpClientWithHeader(clientTimeout, server, header),
+		watchClient: util.NewHttpClientWithHeader(watchTimeout+clientTimeout, server, header),
+	}
+}
+
+// ParseAgentToken 从请求头中解析 agent token
+func ParseAgentToken(header string) string {
+	return strings.TrimPrefix(header, agentTokenPrefix)
+}
+
+type agentResponse struct {
+	Code    int             `json:"code"`
+	Result  json.RawMessage `json:"result"`
+	Message string          `json:"message"`
+}
+
+func (c *agentClient) url(name string, path string) string {
+	return fmt.Sprintf("%s/rainbow/agents/%s/%s", c.server, url.PathEscape(name), path)
+}
+
+// parse 校验 server 的返回码并解析结果
+func (c *agentClient) parse(resp agentResponse, result interface{}) error {
+	if resp.Code != http.StatusOK {
+		return fmt.Errorf("server responded %d: %s", resp.Code, resp.Message)
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (c *agentClient) Register(name string, labels map[string]string, arch string, maxConcurrentTasks int) error {
+	var resp agentResponse
+	if err := c.client.Post(c.url(name, "register"), &resp, map[string]interface{}{
+		"labels":               labels,
+		"arch":                 arch,
+		"max_concurrent_tasks": maxConcurrentTasks,
+	}); err != nil {
+		return err
+	}
+	return c.parse(resp, nil)
+}
+
+func (c *agentClient) Heartbeat(name string) error {
+	var resp agentResponse
+	if err := c.client.Put(c.url(name, "heartbeat"), &resp, nil); err != nil {
+		return err
+	}
+	return c.parse(resp, nil)
+}
+
+func (c *agentClient) Offline(name string) error {
+	var resp agentResponse
+	if err := c.client.Put(c.url(name, "offline"), &resp, nil); err != nil {
+		return err
+	}
+	return c.parse(resp, nil)
+}
+
+func (c *agentClient) WatchTasks(name string, timeout time.Duration) ([]model.Task, error) {
+	var resp agentResponse
+	if err := c.watchClient.Get(c.url(name, fmt.Sprintf("tasks/watch?timeout=%d", int(timeout.Seconds()))), &resp); err != nil {
+		return nil, err
+	}
+
+	var tasks []model.Task
+	if err := c.parse(resp, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (c *agentClient) ClaimTask(name string, taskId int64, resourceVersion int64) (*model.Task, error) {
+	var resp agentResponse
+	if err := c.client.Post(c.url(name, "tasks/claim"), &resp, map[string]interface{}{
+		"task_id":          taskId,
+		"resource_version": resourceVersion,
+	}); err != nil {
+		return nil, err
+	}
+
+	var task *model.Task
+	if err := c.parse(resp, &task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (c *agentClient) GetPluginConfig(name string, taskId int64) (*template.PluginTemplateConfig, error) {
+	var resp agentResponse
+	if err := c.client.Get(c.url(name, fmt.Sprintf("tasks/config?task_id=%d", taskId)), &resp); err != nil {
+		return nil, err
+	}
+
+	var cfg template.PluginTemplateConfig
+	if err := c.parse(resp, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *agentClient) ReportTask(name string, taskId int64, updates map[string]interface{}) error {
+	data := map[string]interface{}{"task_id": taskId}
+	for k, v := range updates {
+		data[k] = v
+	}
+
+	var resp agentResponse
+	if err := c.client.Put(c.url(name, "tasks/result"), &resp, data); err != nil {
+		return err
+	}
+	return c.parse(resp, nil)
+}
+
+func (c *agentClient) ListRuns(name string) ([]model.Task, error) {
+	var resp agentResponse
+	if err := c.client.Get(c.url(name, "runs"), &resp); err != nil {
+		return nil, err
+	}
+
+	var runs []model.Task
+	if err := c.parse(resp, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
@@ -27,9 +27,25 @@ func (s *ServerController) UpdateImage(ctx context.Context, req *types.UpdateIma
 }
 
 func (s *ServerController) UpdateImageStatus(ctx context.Context, req *types.UpdateImageStatusRequest) error {
-	return s.factory.Image().UpdateDirectly(ctx, req.Name, req.TaskId, map[string]interface{}{
+	updates := map[string]interface{}{
 		"status":  req.Status,
 		"message": req.Message,
+	}
+	if len(req.SourceDigest) != 0 {
+		updates["source_digest"] = req.SourceDigest
+	}
+	if len(req.TargetDigest) != 0 {
+		updates["target_digest"] = req.TargetDigest
+	}
+	return s.factory.Image().UpdateDirectly(ctx, req.Name, req.TaskId, updates)
+}
+
+// UpdateImageProgress 只更新进度，不影响镜像的同步状态
+func (s *ServerController) UpdateImageProgress(ctx context.Context, req *types.UpdateImageProgressRequest) error {
+	return s.factory.Image().UpdateDirectly(ctx, req.Name, req.TaskId, map[string]interface{}{
+		"progress_phase": req.Phase,
+		"bytes_done":     req.BytesDone,
+		"bytes_total":    req.BytesTotal,
 	})
 }
 
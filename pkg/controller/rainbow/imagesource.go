@@ -0,0 +1,81 @@
+package rainbow
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/caoyingjunz/rainbow/pkg/imagesource"
+	"github.com/caoyingjunz/rainbow/pkg/types"
+)
+
+// 渲染上传的 chart 的最长时间
+const renderChartTimeout = 2 * time.Minute
+
+// ExtractImages 从 kubernetes manifest 和 helm chart 中提取镜像，不创建任务
+func (s *ServerController) ExtractImages(ctx context.Context, req *types.ExtractImagesRequest) ([]string, error) {
+	return extractImages(ctx, req.Sources)
+}
+
+// extractImages 提取所有来源中的容器镜像，结果去重并排序
+func extractImages(ctx context.Context, sources []types.ImageSource) ([]string, error) {
+	images := sets.NewString()
+	for i, source := range sources {
+		if len(source.Manifests) == 0 && len(source.Chart) == 0 {
+			return nil, fmt.Errorf("image source %d requires manifests or chart", i)
+		}
+
+		if len(source.Manifests) != 0 {
+			found, err := imagesource.FromManifests([]byte(source.Manifests))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse manifests of image source %d: %v", i, err)
+			}
+			images.Insert(found...)
+		}
+		if len(source.Chart) != 0 {
+			found, err := extractChartImages(ctx, source)
+			if err != nil {
+				return nil, fmt.Errorf("failed to extract chart of image source %d: %v", i, err)
+			}
+			images.Insert(found...)
+		}
+	}
+	return images.List(), nil
+}
+
+// extractChartImages 上传的 chart 和 values 写入临时目录后通过 helm 渲染
+func extractChartImages(ctx context.Context, source types.ImageSource) ([]string, error) {
+	data, err := base64.StdEncoding.DecodeString(source.Chart)
+	if err != nil {
+		return nil, fmt.Errorf("chart must be a base64 encoded tgz: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "rainbow-chart-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	chart := imagesource.Chart{Path: filepath.Join(dir, "chart.tgz"), Set: source.Set}
+	if err = ioutil.WriteFile(chart.Path, data, 0600); err != nil {
+		return nil, err
+	}
+	if len(source.Values) != 0 {
+		values := filepath.Join(dir, "values.yaml")
+		if err = ioutil.WriteFile(values, []byte(source.Values), 0600); err != nil {
+			return nil, err
+		}
+		chart.Values = []string{values}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, renderChartTimeout)
+	defer cancel()
+	return imagesource.FromChart(ctx, exec.New(), chart)
+}
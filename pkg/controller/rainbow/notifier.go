@@ -0,0 +1,43 @@
+package rainbow
+
+import (
+	"sync"
+)
+
+// schedulerKey 调度器订阅的 key，出现未分配 agent 的任务时触发
+const schedulerKey = ""
+
+// Notifier 进程内的任务变更通知，订阅者按照 agent 名称区分
+type Notifier struct {
+	lock  sync.Mutex
+	chans map[string]chan struct{}
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{chans: make(map[string]chan struct{})}
+}
+
+// Watch 返回的 channel 会在下一次 Notify 该 key 时被关闭
+func (n *Notifier) Watch(key string) <-chan struct{} {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	ch, ok := n.chans[key]
+	if !ok {
+		ch = make(chan struct{})
+		n.chans[key] = ch
+	}
+	return ch
+}
+
+func (n *Notifier) Notify(keys ...string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, key := range keys {
+		if ch, ok := n.chans[key]; ok {
+			close(ch)
+			delete(n.chans, key)
+		}
+	}
+}
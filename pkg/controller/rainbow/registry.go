@@ -4,28 +4,43 @@ import (
 	"context"
 
 	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
 	"github.com/caoyingjunz/rainbow/pkg/types"
 )
 
 func (s *ServerController) CreateRegistry(ctx context.Context, req *types.CreateRegistryRequest) error {
-	_, err := s.factory.Registry().Create(ctx, &model.Registry{
+	naming, err := registry.EncodeNamingPolicy(req.Naming)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.factory.Registry().Create(ctx, &model.Registry{
 		UserId:     req.UserId,
 		Repository: req.Repository,
 		Namespace:  req.Namespace,
 		Username:   req.Username,
 		Password:   req.Password,
+		Token:      req.Token,
+		Naming:     naming,
 	})
 
 	return err
 }
 
 func (s *ServerController) UpdateRegistry(ctx context.Context, req *types.UpdateRegistryRequest) error {
+	naming, err := registry.EncodeNamingPolicy(req.Naming)
+	if err != nil {
+		return err
+	}
+
 	return s.factory.Registry().Update(ctx, req.Id, req.ResourceVersion, map[string]interface{}{
 		"user_id":    req.UserId,
 		"repository": req.Repository,
 		"namespace":  req.Namespace,
 		"username":   req.Username,
 		"password":   req.Password,
+		"token":      req.Token,
+		"naming":     naming,
 	})
 }
 
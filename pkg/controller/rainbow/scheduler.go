@@ -0,0 +1,200 @@
+package rainbow
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/db/model"
+)
+
+const (
+	RoundRobinPolicy  = "round-robin"
+	LeastLoadedPolicy = "least-loaded"
+	AffinityPolicy    = "affinity"
+	SpreadPolicy      = "spread"
+
+	// ArchLabel agent 的架构会作为内置标签参与选择器匹配，agent 注册时不能使用该标签
+	ArchLabel = "arch"
+)
+
+// SchedulePolicy 从满足任务要求的 agent 中选择一个执行任务
+type SchedulePolicy interface {
+	Name() string
+	Select(task model.Task, candidates []*agentState) *agentState
+}
+
+// agentState 调度过程中 agent 的快照
+type agentState struct {
+	agent  model.Agent
+	labels labels.Set
+	// 未结束的任务数量
+	active int64
+}
+
+func newAgentState(agent model.Agent, active int64) *agentState {
+	set, err := labels.ConvertSelectorToLabelsMap(agent.Labels)
+	if err != nil {
+		set = labels.Set{}
+	}
+	if len(agent.Arch) != 0 {
+		set[ArchLabel] = agent.Arch
+	}
+
+	return &agentState{agent: agent, labels: set, active: active}
+}
+
+func (a *agentState) hasCapacity() bool {
+	return a.agent.MaxConcurrentTasks <= 0 || a.active < int64(a.agent.MaxConcurrentTasks)
+}
+
+func NewSchedulePolicy(opt rainbowconfig.SchedulerOption) (SchedulePolicy, error) {
+	switch opt.Policy {
+	case RoundRobinPolicy:
+		return &roundRobin{}, nil
+	case LeastLoadedPolicy, "":
+		return &leastLoaded{}, nil
+	case AffinityPolicy:
+		return &affinity{preferred: labels.Set(opt.PreferredLabels)}, nil
+	case SpreadPolicy:
+		return &spread{label: opt.SpreadLabel}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported schedule policy %s", opt.Policy)
+}
+
+// filterAgents 过滤出满足任务选择器且仍有并发额度的 agent，无可用 agent 时返回原因
+func filterAgents(task model.Task, agents []*agentState) ([]*agentState, string) {
+	if len(agents) == 0 {
+		return nil, "no online agent available"
+	}
+
+	selector, err := labels.Parse(task.AgentSelector)
+	if err != nil {
+		return nil, fmt.Sprintf("invalid agent selector %s: %v", task.AgentSelector, err)
+	}
+
+	var matched, candidates []*agentState
+	for _, agent := range agents {
+		if !selector.Matches(agent.labels) {
+			continue
+		}
+		matched = append(matched, agent)
+		if agent.hasCapacity() {
+			candidates = append(candidates, agent)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Sprintf("no online agent matches selector %s", task.AgentSelector)
+	}
+	if len(candidates) == 0 {
+		return nil, "all matching agents have reached max concurrent tasks"
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].agent.Name < candidates[j].agent.Name
+	})
+	return candidates, ""
+}
+
+type roundRobin struct {
+	lock sync.Mutex
+	next int
+}
+
+func (r *roundRobin) Name() string { return RoundRobinPolicy }
+
+func (r *roundRobin) Select(task model.Task, candidates []*agentState) *agentState {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	selected := candidates[r.next%len(candidates)]
+	r.next++
+	return selected
+}
+
+type leastLoaded struct{}
+
+func (l *leastLoaded) Name() string { return LeastLoadedPolicy }
+
+func (l *leastLoaded) Select(task model.Task, candidates []*agentState) *agentState {
+	return pickLeastLoaded(candidates)
+}
+
+// affinity 优先选择匹配 preferred 标签最多的 agent，相同时选择负载最低的
+type affinity struct {
+	preferred labels.Set
+}
+
+func (a *affinity) Name() string { return AffinityPolicy }
+
+func (a *affinity) Select(task model.Task, candidates []*agentState) *agentState {
+	var (
+		best      []*agentState
+		bestScore = -1
+	)
+	for _, candidate := range candidates {
+		score := 0
+		for k, v := range a.preferred {
+			if candidate.labels.Has(k) && candidate.labels.Get(k) == v {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = nil, score
+		}
+		if score == bestScore {
+			best = append(best, candidate)
+		}
+	}
+
+	return pickLeastLoaded(best)
+}
+
+// spread 按照指定标签的取值将任务打散，优先选择负载总和最低的标签分组
+type spread struct {
+	label string
+}
+
+func (s *spread) Name() string { return SpreadPolicy }
+
+func (s *spread) Select(task model.Task, candidates []*agentState) *agentState {
+	if len(s.label) == 0 {
+		return pickLeastLoaded(candidates)
+	}
+
+	groups := make(map[string][]*agentState)
+	loads := make(map[string]int64)
+	for _, candidate := range candidates {
+		value := candidate.labels.Get(s.label)
+		groups[value] = append(groups[value], candidate)
+		loads[value] += candidate.active
+	}
+
+	var values []string
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	selected := values[0]
+	for _, value := range values[1:] {
+		if loads[value] < loads[selected] {
+			selected = value
+		}
+	}
+	return pickLeastLoaded(groups[selected])
+}
+
+func pickLeastLoaded(candidates []*agentState) *agentState {
+	selected := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.active < selected.active {
+			selected = candidate
+		}
+	}
+	return selected
+}
@@ -3,11 +3,24 @@ package rainbow
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
 	"github.com/caoyingjunz/rainbow/pkg/db"
 	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/template"
 	"github.com/caoyingjunz/rainbow/pkg/types"
-	"k8s.io/klog/v2"
-	"time"
+	"github.com/caoyingjunz/rainbow/pkg/util/errors"
+)
+
+const (
+	// agent 超过该时间未上报状态则认为已失联
+	agentLostTimeout = 5 * time.Minute
+
+	// 调度器在没有任务变更通知时的兜底周期
+	scheduleResyncPeriod = 30 * time.Second
 )
 
 type ServerGetter interface {
@@ -25,9 +38,20 @@ type ServerInterface interface {
 	UpdateTask(ctx context.Context, req *types.UpdateTaskRequest) error
 	ListTasks(ctx context.Context, userId string) (interface{}, error)
 	UpdateTaskStatus(ctx context.Context, req *types.UpdateTaskStatusRequest) error
+	CancelTask(ctx context.Context, taskId int64) error
+	GetTaskRun(ctx context.Context, taskId int64) (*types.TaskRun, error)
 
 	GetAgent(ctx context.Context, agentId int64) (interface{}, error)
 	ListAgents(ctx context.Context) (interface{}, error)
+	WatchAgentTasks(ctx context.Context, agentName string, timeout time.Duration) ([]model.Task, error)
+
+	RegisterAgent(ctx context.Context, agentName string, req *types.RegisterAgentRequest) error
+	AgentHeartbeat(ctx context.Context, agentName string) error
+	AgentOffline(ctx context.Context, agentName string) error
+	ClaimTask(ctx context.Context, agentName string, req *types.ClaimTaskRequest) (*model.Task, error)
+	GetPluginConfig(ctx context.Context, agentName string, taskId int64) (*template.PluginTemplateConfig, error)
+	ReportTask(ctx context.Context, agentName string, req *types.ReportTaskRequest) error
+	ListAgentRuns(ctx context.Context, agentName string) ([]model.Task, error)
 
 	CreateImage(ctx context.Context, req *types.CreateImageRequest) error
 	UpdateImage(ctx context.Context, req *types.UpdateImageRequest) error
@@ -35,17 +59,32 @@ type ServerInterface interface {
 	ListImages(ctx context.Context, taskId int64) (interface{}, error)
 
 	UpdateImageStatus(ctx context.Context, req *types.UpdateImageStatusRequest) error
+	UpdateImageProgress(ctx context.Context, req *types.UpdateImageProgressRequest) error
+	ExtractImages(ctx context.Context, req *types.ExtractImagesRequest) ([]string, error)
 
 	Run(ctx context.Context, workers int) error
 }
 
 type ServerController struct {
-	factory db.ShareDaoFactory
+	factory  db.ShareDaoFactory
+	cfg      rainbowconfig.Config
+	notifier *Notifier
+
+	policy SchedulePolicy
 }
 
-func NewServer(f db.ShareDaoFactory) *ServerController {
+func NewServer(f db.ShareDaoFactory, cfg rainbowconfig.Config, notifier *Notifier) *ServerController {
+	policy, err := NewSchedulePolicy(cfg.Server.Scheduler)
+	if err != nil {
+		klog.Errorf("%v, fallback to %s", err, LeastLoadedPolicy)
+		policy = &leastLoaded{}
+	}
+
 	return &ServerController{
-		factory: f,
+		factory:  f,
+		cfg:      cfg,
+		notifier: notifier,
+		policy:   policy,
 	}
 }
 
@@ -57,6 +96,29 @@ func (s *ServerController) ListAgents(ctx context.Context) (interface{}, error)
 	return s.factory.Agent().List(ctx)
 }
 
+// WatchAgentTasks 获取分配给 agent 且尚未被认领的任务，没有任务时等待任务变更通知或者超时
+func (s *ServerController) WatchAgentTasks(ctx context.Context, agentName string, timeout time.Duration) ([]model.Task, error) {
+	// 先订阅再查询，避免错过两者之间的变更
+	ch := s.notifier.Watch(agentName)
+
+	tasks, err := s.factory.Task().ListWithAgent(ctx, agentName, 0)
+	if err != nil || len(tasks) != 0 {
+		return tasks, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case <-timer.C:
+		return nil, nil
+	case <-ch:
+	}
+	return s.factory.Task().ListWithAgent(ctx, agentName, 0)
+}
+
 func (s *ServerController) Run(ctx context.Context, workers int) error {
 	go s.monitor(ctx)
 	go s.schedule(ctx)
@@ -67,12 +129,22 @@ func (s *ServerController) Run(ctx context.Context, workers int) error {
 func (s *ServerController) schedule(ctx context.Context) {
 	klog.Infof("starting scheduler controller")
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(scheduleResyncPeriod)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		// 先订阅再调度，避免错过调度期间的通知
+		ch := s.notifier.Watch(schedulerKey)
 		if err := s.doSchedule(ctx); err != nil {
-			klog.Error("failed to do schedule %v", err)
+			klog.Errorf("failed to do schedule %v", err)
+		}
+
+		// 有新的未分配任务时立即调度，多副本部署时依赖周期性的兜底调度
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ch:
 		}
 	}
 }
@@ -82,40 +154,159 @@ func (s *ServerController) doSchedule(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if len(tasks) == 0 {
+		return nil
+	}
 	agents, err := s.factory.Agent().List(ctx)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("tasks", tasks)
-	fmt.Println("agents", agents)
+	active, err := s.factory.Task().CountActiveByAgent(ctx)
+	if err != nil {
+		return err
+	}
+
+	var online []*agentState
+	for _, agent := range agents {
+		if isAgentOnline(agent) {
+			online = append(online, newAgentState(agent, active[agent.Name]))
+		}
+	}
+
+	for _, task := range tasks {
+		candidates, reason := filterAgents(task, online)
+		if len(candidates) == 0 {
+			s.markUnschedulable(ctx, task, reason)
+			continue
+		}
+
+		selected := s.policy.Select(task, candidates)
+		agentName := selected.agent.Name
+		if err = s.factory.Task().AssignToAgent(ctx, task.Id, task.ResourceVersion, agentName); err != nil {
+			// 已被其他 server 副本调度或者任务已被更新，等待下一轮处理
+			if errors.IsNotUpdated(err) {
+				continue
+			}
+			klog.Errorf("failed to assign task %d to agent %s: %v", task.Id, agentName, err)
+			continue
+		}
+		selected.active++
+		s.notifier.Notify(agentName)
+		klog.Infof("task %d has been scheduled to agent %s by %s policy", task.Id, agentName, s.policy.Name())
+	}
+
 	return nil
 }
 
+// markUnschedulable 记录任务无法调度的原因，原因未变化时不重复写入
+func (s *ServerController) markUnschedulable(ctx context.Context, task model.Task, reason string) {
+	if task.Message == reason {
+		return
+	}
+	if err := s.factory.Task().UpdateDirectly(ctx, task.Id, map[string]interface{}{"message": reason}); err != nil {
+		klog.Errorf("failed to record task %d unschedulable reason: %v", task.Id, err)
+	}
+}
+
+func isAgentOnline(agent model.Agent) bool {
+	if agent.Status != model.RunAgentType {
+		return false
+	}
+	return time.Since(agent.LastTransitionTime) < agentLostTimeout
+}
+
 func (s *ServerController) monitor(ctx context.Context) {
 	klog.Infof("starting agent monitor")
 
 	ticker := time.NewTicker(60 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		agents, err := s.factory.Agent().List(ctx)
 		if err != nil {
-			klog.Error("failed to get agents %v", err)
+			klog.Errorf("failed to get agents %v", err)
 			continue
 		}
 
 		for _, agent := range agents {
-			diff := time.Now().Sub(agent.LastTransitionTime)
-			if diff > time.Minute*5 {
-				if agent.Status == model.UnknownAgentType {
-					continue
+			// 正常退出的 agent 上尚未认领的任务立即重新调度
+			if agent.Status == model.UnRunAgentType {
+				if err = s.requeueAgentTasks(ctx, agent.Name, false); err != nil {
+					klog.Errorf("failed to requeue tasks of offline agent %s: %v", agent.Name, err)
 				}
+				continue
+			}
+
+			diff := time.Now().Sub(agent.LastTransitionTime)
+			if diff <= agentLostTimeout {
+				continue
+			}
+			if agent.Status != model.UnknownAgentType {
 				err = s.factory.Agent().UpdateByName(ctx, agent.Name, map[string]interface{}{"status": model.UnknownAgentType, "message": "Agent stopped posting status"})
 				if err != nil {
-					klog.Error("failed to sync agent %s status %v", agent.Name, err)
+					klog.Errorf("failed to sync agent %s status %v", agent.Name, err)
+					continue
 				}
 			}
+
+			// 失联 agent 上未完成的任务重新交给调度器处理
+			if err = s.requeueAgentTasks(ctx, agent.Name, true); err != nil {
+				klog.Errorf("failed to requeue tasks of lost agent %s: %v", agent.Name, err)
+			}
+		}
+	}
+}
+
+// requeueAgentTasks 释放 agent 上未完成的任务，claimed 为 false 时只释放尚未被 agent 认领的任务，
+// 已认领的任务超过最大重新调度次数后直接置为失败
+func (s *ServerController) requeueAgentTasks(ctx context.Context, agentName string, claimed bool) error {
+	tasks, err := s.factory.Task().ListActiveWithAgent(ctx, agentName)
+	if err != nil {
+		return err
+	}
+
+	// 默认值由 options 填充
+	maxReassignments := *s.cfg.Server.Scheduler.MaxReassignments
+	for _, task := range tasks {
+		var updates map[string]interface{}
+		if task.Process == 0 {
+			updates = map[string]interface{}{
+				"agent_name": "",
+				"message":    fmt.Sprintf("agent %s offline, task released for rescheduling", agentName),
+			}
+		} else if !claimed {
+			continue
+		} else if maxReassignments >= 0 && task.Attempts >= maxReassignments {
+			updates = map[string]interface{}{
+				"status":  model.TaskRunFailedStatus,
+				"message": fmt.Sprintf("agent %s lost and task has been reassigned %d times", agentName, task.Attempts),
+			}
+		} else {
+			updates = map[string]interface{}{
+				"agent_name": "",
+				"process":    0,
+				"attempts":   task.Attempts + 1,
+				// 重新调度后由新的 agent 分发，之前的运行不再同步
+				"dispatch_handle": "",
+				"run_status":      "",
+				"message":         fmt.Sprintf("agent %s lost, task released for rescheduling", agentName),
+			}
 		}
+
+		if err = s.factory.Task().Update(ctx, task.Id, task.ResourceVersion, updates); err != nil {
+			klog.Errorf("failed to requeue task %d from agent %s: %v", task.Id, agentName, err)
+			continue
+		}
+		klog.Infof("task %d of agent %s: %s", task.Id, agentName, updates["message"])
+		s.notifier.Notify(schedulerKey)
 	}
+
+	return nil
 }
@@ -3,22 +3,64 @@ package rainbow
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/caoyingjunz/rainbow/pkg/db/model"
+	"github.com/caoyingjunz/rainbow/pkg/kubeimages"
+	"github.com/caoyingjunz/rainbow/pkg/registry"
 	"github.com/caoyingjunz/rainbow/pkg/types"
 )
 
 func (s *ServerController) CreateTask(ctx context.Context, req *types.CreateTaskRequest) error {
+	if _, err := labels.Parse(req.AgentSelector); err != nil {
+		return fmt.Errorf("invalid agent selector %s: %v", req.AgentSelector, err)
+	}
+	if _, err := registry.ParsePlatforms(req.Platforms); err != nil {
+		return err
+	}
+	naming, err := registry.EncodeNamingPolicy(req.Naming)
+	if err != nil {
+		return err
+	}
+	if req.Images, err = mergeImageSources(ctx, req.Images, req.Sources); err != nil {
+		return err
+	}
+	if req.Images, err = s.mergeKubernetesImages(req.Images, req.KubernetesVersions, req.Addons); err != nil {
+		return err
+	}
+	reg, err := s.factory.Registry().Get(ctx, req.RegisterId)
+	if err != nil {
+		return fmt.Errorf("failed to get registry %v", err)
+	}
+	if req.Images, err = expandTagPatterns(ctx, reg, req.Images, req.MaxTags); err != nil {
+		return err
+	}
+	if err = checkTargetCollisions(reg, req.Naming, req.Images); err != nil {
+		return err
+	}
+
 	object, err := s.factory.Task().Create(ctx, &model.Task{
-		Name:       req.Name,
-		UserId:     req.UserId,
-		RegisterId: req.RegisterId,
-		AgentName:  req.AgentName,
+		Name:          req.Name,
+		UserId:        req.UserId,
+		RegisterId:    req.RegisterId,
+		AgentName:     req.AgentName,
+		AgentSelector: req.AgentSelector,
+		Platforms:     req.Platforms,
+		Force:         req.Force,
+		Naming:        naming,
+
+		KubernetesVersions: strings.Join(req.KubernetesVersions, ","),
+		Addons:             strings.Join(req.Addons, ","),
 	})
 	if err != nil {
 		return err
 	}
+	// 未指定 agent 时 AgentName 为空，通知的是调度器
+	defer s.notifier.Notify(object.AgentName)
 
 	if len(req.Images) == 0 {
 		return nil
@@ -42,9 +84,36 @@ func (s *ServerController) CreateTask(ctx context.Context, req *types.CreateTask
 }
 
 func (s *ServerController) UpdateTask(ctx context.Context, req *types.UpdateTaskRequest) error {
-	if err := s.factory.Task().Update(ctx, req.Id, req.ResourceVersion, map[string]interface{}{
-		"register_id": req.RegisterId,
-	}); err != nil {
+	naming, err := registry.EncodeNamingPolicy(req.Naming)
+	if err != nil {
+		return err
+	}
+	if req.Images, err = mergeImageSources(ctx, req.Images, req.Sources); err != nil {
+		return err
+	}
+	if req.Images, err = s.mergeKubernetesImages(req.Images, req.KubernetesVersions, req.Addons); err != nil {
+		return err
+	}
+	task, err := s.factory.Task().Get(ctx, req.Id)
+	if err != nil {
+		return err
+	}
+	// 请求中未指定的字段保持任务当前的值
+	registerId := req.RegisterId
+	if registerId == 0 {
+		registerId = task.RegisterId
+	}
+	taskNaming := req.Naming
+	if taskNaming.IsEmpty() {
+		if taskNaming, err = registry.DecodeNamingPolicy(task.Naming); err != nil {
+			return err
+		}
+	}
+	reg, err := s.factory.Registry().Get(ctx, registerId)
+	if err != nil {
+		return fmt.Errorf("failed to get registry %v", err)
+	}
+	if req.Images, err = expandTagPatterns(ctx, reg, req.Images, req.MaxTags); err != nil {
 		return err
 	}
 
@@ -58,6 +127,26 @@ func (s *ServerController) UpdateTask(ctx context.Context, req *types.UpdateTask
 	}
 	oldImageMap := sets.NewString(oldImages...)
 
+	// 已有的镜像不会被删除，需要和新增的镜像一起检查
+	if err = checkTargetCollisions(reg, taskNaming, append(oldImages, req.Images...)); err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"register_id":         registerId,
+		"kubernetes_versions": appendList(task.KubernetesVersions, req.KubernetesVersions),
+		"addons":              appendList(task.Addons, req.Addons),
+	}
+	if req.Force != nil {
+		updates["force"] = *req.Force
+	}
+	if !req.Naming.IsEmpty() {
+		updates["naming"] = naming
+	}
+	if err = s.factory.Task().Update(ctx, req.Id, req.ResourceVersion, updates); err != nil {
+		return err
+	}
+
 	var addImages []string
 	for _, n := range req.Images {
 		if oldImageMap.Has(n) {
@@ -76,9 +165,125 @@ func (s *ServerController) UpdateTask(ctx context.Context, req *types.UpdateTask
 		return fmt.Errorf("failed to create tasks images %v", err)
 	}
 
+	s.notifier.Notify(task.AgentName)
+	return nil
+}
+
+// 展开镜像 tag 匹配规则的最长时间
+const expandTagsTimeout = time.Minute
+
+// mergeImageSources 将镜像来源中提取的镜像追加到任务的镜像中，已有的镜像不重复添加
+func mergeImageSources(ctx context.Context, images []string, sources []types.ImageSource) ([]string, error) {
+	if len(sources) == 0 {
+		return images, nil
+	}
+	extracted, err := extractImages(ctx, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendMissing(images, extracted), nil
+}
+
+// mergeKubernetesImages 将 kubernetes 版本和附加组件展开为具体的镜像，追加到任务的镜像中
+func (s *ServerController) mergeKubernetesImages(images []string, versions []string, addons []string) ([]string, error) {
+	var expanded []string
+	if len(versions) != 0 {
+		resolver, err := kubeimages.NewResolver(s.cfg.Kubernetes.VersionTable)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			kubeImages, err := resolver.Images(strings.TrimSpace(version), s.cfg.Kubernetes.ImageRepository)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, kubeImages...)
+		}
+	}
+	for _, addon := range addons {
+		addonImages, err := kubeimages.AddonImages(addon)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, addonImages...)
+	}
+
+	return appendMissing(images, expanded), nil
+}
+
+// expandTagPatterns 在创建任务时通过源仓库的 tags API 将匹配规则展开为具体的镜像，
+// 源镜像位于任务的目标仓库时使用该仓库的认证信息，其他仓库匿名访问
+func expandTagPatterns(ctx context.Context, reg *model.Registry, images []string, max int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, expandTagsTimeout)
+	defer cancel()
+
+	expanded, err := newRegistryClient(reg).ExpandTagPatterns(ctx, images, max)
+	if err != nil {
+		return nil, err
+	}
+	return appendMissing(nil, expanded), nil
+}
+
+// appendMissing 按顺序追加 list 中不存在的元素
+func appendMissing(list []string, items []string) []string {
+	exists := sets.NewString(list...)
+	for _, item := range items {
+		if !exists.Has(item) {
+			exists.Insert(item)
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// appendList 在逗号分隔的列表中追加不存在的元素
+func appendList(list string, items []string) string {
+	var result []string
+	if len(list) != 0 {
+		result = strings.Split(list, ",")
+	}
+	return strings.Join(appendMissing(result, items), ",")
+}
+
+// newRegistryClient 和插件一致，使用仓库的用户名密码或者 token 访问仓库
+func newRegistryClient(reg *model.Registry) *registry.Client {
+	host := reg.Repository
+	if len(host) == 0 {
+		host = registry.DefaultRegistry
+	}
+	return registry.NewClient(registry.WithAuth(host, registry.Credential{
+		Username:      reg.Username,
+		Password:      reg.Password,
+		RegistryToken: reg.Token,
+	}))
+}
+
+// checkTargetCollisions 拒绝不同源镜像同步到同一个目标镜像的任务
+func checkTargetCollisions(reg *model.Registry, naming registry.NamingPolicy, images []string) error {
+	naming, err := effectiveNamingPolicy(reg.Naming, naming)
+	if err != nil {
+		return err
+	}
+
+	collisions, err := registry.FindCollisions(naming, reg.Repository, reg.Namespace, images)
+	if err != nil {
+		return err
+	}
+	if len(collisions) != 0 {
+		return fmt.Errorf("images collide on the same target: %s", strings.Join(collisions, "; "))
+	}
 	return nil
 }
 
+// effectiveNamingPolicy 任务未指定命名规则时使用仓库的命名规则
+func effectiveNamingPolicy(registryNaming string, taskNaming registry.NamingPolicy) (registry.NamingPolicy, error) {
+	if !taskNaming.IsEmpty() {
+		return taskNaming, nil
+	}
+	return registry.DecodeNamingPolicy(registryNaming)
+}
+
 func (s *ServerController) ListTasks(ctx context.Context, userId string) (interface{}, error) {
 	if len(userId) == 0 {
 		return s.factory.Task().List(ctx)
@@ -91,6 +296,40 @@ func (s *ServerController) UpdateTaskStatus(ctx context.Context, req *types.Upda
 	return s.factory.Task().UpdateDirectly(ctx, req.TaskId, map[string]interface{}{"status": req.Status, "message": req.Message})
 }
 
+// CancelTask 请求取消任务的运行，由执行任务的 agent 取消后将任务置为已取消
+func (s *ServerController) CancelTask(ctx context.Context, taskId int64) error {
+	task, err := s.factory.Task().Get(ctx, taskId)
+	if err != nil {
+		return err
+	}
+	if model.IsTaskTerminal(task.Status) {
+		return fmt.Errorf("task %d has already finished with status %s", taskId, task.Status)
+	}
+	if len(task.DispatchHandle) == 0 {
+		return fmt.Errorf("task %d has not been dispatched yet", taskId)
+	}
+
+	return s.factory.Task().UpdateDirectly(ctx, taskId, map[string]interface{}{"cancel_requested": true})
+}
+
+// GetTaskRun 获取任务本次运行的状态和 agent 最近上报的日志
+func (s *ServerController) GetTaskRun(ctx context.Context, taskId int64) (*types.TaskRun, error) {
+	task, err := s.factory.Task().Get(ctx, taskId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.TaskRun{
+		TaskId:          task.Id,
+		Status:          task.Status,
+		Dispatcher:      task.Dispatcher,
+		DispatchHandle:  task.DispatchHandle,
+		RunStatus:       task.RunStatus,
+		CancelRequested: task.CancelRequested,
+		Logs:            task.RunLogs,
+	}, nil
+}
+
 func (s *ServerController) DeleteTaskWithImages(ctx context.Context, taskId int64) error {
 	_ = s.factory.Image().DeleteInBatch(ctx, taskId)
 	_ = s.factory.Task().Delete(ctx, taskId)
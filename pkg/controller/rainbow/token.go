@@ -0,0 +1,57 @@
+package rainbow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/caoyingjunz/pixiulib/strutil"
+)
+
+// 插件回调 token 的前缀，格式为 task-<任务 id>.<签名>
+const callbackTokenPrefix = "task-"
+
+func sign(secret string, message string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(message))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AgentToken 生成 agent 的 token，token 和 agent 名称绑定，持有者只能以该名称调用 agent 接口
+func AgentToken(secret string, agentName string) string {
+	return sign(secret, "agent/"+agentName)
+}
+
+// VerifyAgentToken 校验 token 是否为指定 agent 的 token
+func VerifyAgentToken(secret string, agentName string, token string) bool {
+	if len(secret) == 0 || len(agentName) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(AgentToken(secret, agentName)))
+}
+
+// CallbackToken 为任务生成插件回调使用的 token，只能用于上报该任务及其镜像的状态
+func CallbackToken(secret string, taskId int64) string {
+	return fmt.Sprintf("%s%d.%s", callbackTokenPrefix, taskId, sign(secret, fmt.Sprintf("task/%d", taskId)))
+}
+
+// ParseCallbackToken 校验插件回调 token，返回 token 对应的任务 id
+func ParseCallbackToken(secret string, token string) (int64, bool) {
+	if len(secret) == 0 || !strings.HasPrefix(token, callbackTokenPrefix) {
+		return 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, callbackTokenPrefix), ".", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	taskId, err := strutil.ParseInt64(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	if !hmac.Equal([]byte(token), []byte(CallbackToken(secret, taskId))) {
+		return 0, false
+	}
+	return taskId, true
+}
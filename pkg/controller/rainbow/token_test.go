@@ -0,0 +1,58 @@
+package rainbow
+
+import "testing"
+
+func TestVerifyAgentToken(t *testing.T) {
+	token := AgentToken("secret", "agent-a")
+
+	tests := []struct {
+		name   string
+		secret string
+		agent  string
+		token  string
+		want   bool
+	}{
+		{name: "valid", secret: "secret", agent: "agent-a", token: token, want: true},
+		{name: "other agent", secret: "secret", agent: "agent-b", token: token},
+		{name: "shared secret", secret: "secret", agent: "agent-a", token: "secret"},
+		{name: "other secret", secret: "other", agent: "agent-a", token: token},
+		{name: "no secret", agent: "agent-a", token: AgentToken("", "agent-a")},
+		{name: "callback token", secret: "secret", agent: "agent-a", token: CallbackToken("secret", 1)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := VerifyAgentToken(tc.secret, tc.agent, tc.token); got != tc.want {
+				t.Errorf("VerifyAgentToken() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCallbackToken(t *testing.T) {
+	token := CallbackToken("secret", 12)
+
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		want   int64
+		wantOk bool
+	}{
+		{name: "valid", secret: "secret", token: token, want: 12, wantOk: true},
+		{name: "other secret", secret: "other", token: token},
+		{name: "no secret", token: CallbackToken("", 12)},
+		{name: "task id changed", secret: "secret", token: "task-13" + token[len("task-12"):]},
+		{name: "malformed", secret: "secret", token: "task-12"},
+		{name: "agent token", secret: "secret", token: "secret"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := ParseCallbackToken(tc.secret, tc.token)
+			if ok != tc.wantOk || got != tc.want {
+				t.Errorf("ParseCallbackToken() = %d, %v, want %d, %v", got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
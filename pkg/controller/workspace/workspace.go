@@ -0,0 +1,236 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	rainbowconfig "github.com/caoyingjunz/rainbow/cmd/app/config"
+	"github.com/caoyingjunz/rainbow/pkg/util"
+)
+
+const (
+	repoDirName  = "plugin"
+	tasksDirName = "tasks"
+
+	gcInterval = 10 * time.Minute
+)
+
+// Manager 管理 agent 在 DataDir 下的工作目录
+// 所有任务共用 DataDir/plugin 这一份插件仓库，每个任务的运行文件位于 DataDir/tasks/<taskId>，
+// 任务结束或超过 TTL 后清理，总占用超过上限时优先清理最早的任务目录
+type Manager struct {
+	baseDir string
+	ttl     time.Duration
+	maxSize int64
+
+	// 串行化对共享仓库的操作
+	repoLock sync.Mutex
+
+	lock   sync.Mutex
+	active map[int64]bool
+}
+
+func New(baseDir string, opt rainbowconfig.WorkspaceOption) *Manager {
+	return &Manager{
+		baseDir: baseDir,
+		ttl:     opt.TTL,
+		maxSize: opt.MaxSizeMB * 1024 * 1024,
+		active:  make(map[int64]bool),
+	}
+}
+
+// WithRepo 在持有仓库锁的情况下操作共享的插件仓库
+func (m *Manager) WithRepo(fn func(repoDir string) error) error {
+	m.repoLock.Lock()
+	defer m.repoLock.Unlock()
+
+	return fn(filepath.Join(m.baseDir, repoDirName))
+}
+
+// TaskDir 返回任务的工作目录，目录在 Release 之前不会被回收
+func (m *Manager) TaskDir(taskId int64) (string, error) {
+	dir := filepath.Join(m.baseDir, tasksDirName, strconv.FormatInt(taskId, 10))
+	if err := util.EnsureDirectoryExists(dir); err != nil {
+		return "", err
+	}
+
+	m.lock.Lock()
+	m.active[taskId] = true
+	m.lock.Unlock()
+	return dir, nil
+}
+
+// Release 任务结束后释放工作目录，remove 为 false 时目录保留到 TTL 到期
+func (m *Manager) Release(taskId int64, remove bool) {
+	m.lock.Lock()
+	delete(m.active, taskId)
+	m.lock.Unlock()
+
+	if remove {
+		util.RemoveFile(filepath.Join(m.baseDir, tasksDirName, strconv.FormatInt(taskId, 10)))
+	}
+}
+
+func (m *Manager) isActive(taskId int64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.active[taskId]
+}
+
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.GC(); err != nil {
+			klog.Errorf("failed to gc workspace %s: %v", m.baseDir, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type taskDir struct {
+	path    string
+	modTime time.Time
+	size    int64
+	active  bool
+}
+
+// GC 清理过期的任务目录和共享仓库中残留的任务分支，并保证总占用不超过上限
+func (m *Manager) GC() error {
+	if err := m.WithRepo(pruneBranches); err != nil {
+		klog.Errorf("failed to prune branches: %v", err)
+	}
+
+	dirs, err := m.listTaskDirs()
+	if err != nil {
+		return err
+	}
+	total, err := dirSize(filepath.Join(m.baseDir, repoDirName))
+	if err != nil {
+		return err
+	}
+
+	var remains []taskDir
+	for _, dir := range dirs {
+		if !dir.active && m.ttl > 0 && time.Since(dir.modTime) > m.ttl {
+			klog.V(2).Infof("removing expired task workspace %s", dir.path)
+			util.RemoveFile(dir.path)
+			continue
+		}
+		total += dir.size
+		if !dir.active {
+			remains = append(remains, dir)
+		}
+	}
+
+	if m.maxSize <= 0 {
+		return nil
+	}
+	sort.Slice(remains, func(i, j int) bool { return remains[i].modTime.Before(remains[j].modTime) })
+	for _, dir := range remains {
+		if total <= m.maxSize {
+			break
+		}
+		klog.Infof("workspace usage %d exceeds %d, removing task workspace %s", total, m.maxSize, dir.path)
+		util.RemoveFile(dir.path)
+		total -= dir.size
+	}
+	if total > m.maxSize {
+		return fmt.Errorf("workspace usage %d still exceeds %d", total, m.maxSize)
+	}
+	return nil
+}
+
+// listTaskDirs 列出所有的任务目录，包括旧版本在 DataDir/<taskId> 下拷贝的插件仓库
+func (m *Manager) listTaskDirs() ([]taskDir, error) {
+	var dirs []taskDir
+	for _, parent := range []string{filepath.Join(m.baseDir, tasksDirName), m.baseDir} {
+		entries, err := ioutil.ReadDir(parent)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			taskId, err := strconv.ParseInt(entry.Name(), 10, 64)
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(parent, entry.Name())
+			if parent == m.baseDir && !util.IsDirectoryExists(filepath.Join(path, repoDirName, ".git")) {
+				continue
+			}
+
+			size, err := dirSize(path)
+			if err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, taskDir{path: path, modTime: entry.ModTime(), size: size, active: m.isActive(taskId)})
+		}
+	}
+
+	return dirs, nil
+}
+
+// pruneBranches 删除共享仓库中残留的任务分支
+func pruneBranches(repoDir string) error {
+	if !util.IsDirectoryExists(repoDir) {
+		return nil
+	}
+
+	git := util.NewGit(repoDir, "", "")
+	current, err := git.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	branches, err := git.LocalBranches()
+	if err != nil {
+		return err
+	}
+	for _, branch := range branches {
+		if branch == current || branch == "master" || branch == "main" {
+			continue
+		}
+		if err = git.DeleteBranch(branch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -36,6 +36,10 @@ func (m *migrator) CreateTables(dst ...interface{}) error {
 
 	for _, d := range dst {
 		if db.Migrator().HasTable(d) {
+			// 表已存在时补齐新增的字段
+			if err := db.Migrator().AutoMigrate(d); err != nil {
+				return err
+			}
 			continue
 		}
 		if err := db.Migrator().CreateTable(d); err != nil {
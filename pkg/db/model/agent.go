@@ -27,6 +27,11 @@ type Agent struct {
 	Type               string    `json:"type"`
 	Status             string    `gorm:"column:status;" json:"status"`
 	Message            string    `json:"message"`
+
+	// 调度相关属性，Labels 格式为 k1=v1,k2=v2
+	Labels             string `json:"labels"`
+	Arch               string `json:"arch"`
+	MaxConcurrentTasks int    `json:"max_concurrent_tasks"` // 0 表示不限制
 }
 
 func (a *Agent) TableName() string {
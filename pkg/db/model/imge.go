@@ -8,6 +8,14 @@ func init() {
 	register(&Image{})
 }
 
+const (
+	ImageRunningStatus   string = "进行中"
+	ImageFailedStatus    string = "异常"
+	ImageSucceededStatus string = "完成"
+	// ImageSkippedStatus 目标仓库已存在相同镜像，未重新同步
+	ImageSkippedStatus string = "已存在"
+)
+
 type Image struct {
 	rainbow.Model
 
@@ -15,6 +23,15 @@ type Image struct {
 	Name    string `json:"name"`
 	Status  string `json:"status"`
 	Message string `json:"message"`
+
+	// 同步前源镜像的 manifest digest 和推送后目标镜像的 manifest digest
+	SourceDigest string `json:"source_digest"`
+	TargetDigest string `json:"target_digest"`
+
+	// 同步进度，phase 为 copy, pull 或 push，字节数为当前阶段的进度
+	ProgressPhase string `json:"progress_phase"`
+	BytesDone     int64  `json:"bytes_done"`
+	BytesTotal    int64  `json:"bytes_total"`
 }
 
 func (t *Image) TableName() string {
@@ -17,6 +17,11 @@ type Registry struct {
 	Namespace  string `json:"namespace"`
 	Username   string `json:"username"`
 	Password   string `json:"password"`
+	// 使用 token 认证的仓库，直接作为 Bearer token 访问仓库
+	Token string `json:"token"`
+
+	// 源镜像到目标镜像的命名规则，json 格式，为空时只保留源镜像路径的最后一段
+	Naming string `json:"naming"`
 }
 
 func (t *Registry) TableName() string {
@@ -8,6 +8,38 @@ func init() {
 	register(&Task{})
 }
 
+const (
+	TaskInitFailedStatus string = "初始化失败"
+	// TaskRunFailedStatus 任务执行失败或者所有镜像都同步失败
+	TaskRunFailedStatus     string = "执行失败"
+	TaskSucceededStatus     string = "同步成功"
+	TaskPartialFailedStatus string = "部分失败"
+	// TaskFinishedStatus 旧版本插件的结束状态，不区分镜像是否同步成功
+	TaskFinishedStatus string = "镜像推送结束"
+	// TaskCanceledStatus 用户取消了任务的运行
+	TaskCanceledStatus string = "已取消"
+)
+
+// TaskTerminalStatuses 任务的终态，处于终态的任务不再占用 agent 的并发额度
+var TaskTerminalStatuses = []string{
+	TaskInitFailedStatus,
+	TaskRunFailedStatus,
+	TaskSucceededStatus,
+	TaskPartialFailedStatus,
+	TaskFinishedStatus,
+	TaskCanceledStatus,
+}
+
+// IsTaskTerminal 任务是否处于终态
+func IsTaskTerminal(status string) bool {
+	for _, s := range TaskTerminalStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 type Task struct {
 	rainbow.Model
 
@@ -18,6 +50,28 @@ type Task struct {
 	Process    int    `json:"process"`
 	Status     string `json:"status"`
 	Message    string `json:"message"`
+
+	// 任务对 agent 的标签选择器，例如 arch=arm64,zone in (bj,sh)
+	AgentSelector string `json:"agent_selector"`
+	// 同步的镜像平台，逗号分隔，例如 linux/amd64,linux/arm64，为空时同步所有平台
+	Platforms string `json:"platforms"`
+	// 任务的命名规则，json 格式，为空时使用仓库的命名规则
+	Naming string `json:"naming"`
+	// 为 true 时目标仓库已存在相同镜像也重新同步
+	Force bool `json:"force"`
+	// 任务包含的 kubernetes 版本和附加组件，逗号分隔，对应的镜像已展开为任务的镜像
+	KubernetesVersions string `json:"kubernetes_versions"`
+	Addons             string `json:"addons"`
+	// 任务因 agent 失联被重新调度的次数
+	Attempts int `json:"attempts"`
+
+	// 执行插件的后端及本次运行的句柄，用于查询状态，取消运行和获取日志
+	Dispatcher     string `json:"dispatcher"`
+	DispatchHandle string `json:"dispatch_handle"`
+	// agent 定期上报的运行状态和日志尾部，用户请求取消后由 agent 取消运行
+	RunStatus       string `json:"run_status"`
+	RunLogs         string `gorm:"type:text" json:"-"`
+	CancelRequested bool   `json:"cancel_requested"`
 }
 
 func (t *Task) TableName() string {
@@ -21,10 +21,12 @@ type TaskInterface interface {
 	UpdateDirectly(ctx context.Context, taskId int64, updates map[string]interface{}) error
 
 	GetOne(ctx context.Context, taskId int64, resourceVersion int64) (*model.Task, error)
-	AssignToAgent(ctx context.Context, taskId int64, agentName string) error
+	AssignToAgent(ctx context.Context, taskId int64, resourceVersion int64, agentName string) error
 	ListWithAgent(ctx context.Context, agentName string, process int, opts ...Options) ([]model.Task, error)
 	ListWithNoAgent(ctx context.Context, process int, opts ...Options) ([]model.Task, error)
+	ListActiveWithAgent(ctx context.Context, agentName string, opts ...Options) ([]model.Task, error)
 	ListWithUser(ctx context.Context, userId string, opts ...Options) ([]model.Task, error)
+	CountActiveByAgent(ctx context.Context) (map[string]int64, error)
 }
 
 func newTask(db *gorm.DB) TaskInterface {
@@ -142,6 +144,20 @@ func (a *task) ListWithNoAgent(ctx context.Context, process int, opts ...Options
 	return audits, nil
 }
 
+// ListActiveWithAgent 获取指定 agent 上尚未结束的任务
+func (a *task) ListActiveWithAgent(ctx context.Context, agentName string, opts ...Options) ([]model.Task, error) {
+	var audits []model.Task
+	tx := a.db.WithContext(ctx)
+	for _, opt := range opts {
+		tx = opt(tx)
+	}
+	if err := tx.Where("agent_name = ? and status not in ?", agentName, model.TaskTerminalStatuses).Find(&audits).Error; err != nil {
+		return nil, err
+	}
+
+	return audits, nil
+}
+
 func (a *task) ListWithUser(ctx context.Context, userId string, opts ...Options) ([]model.Task, error) {
 	var audits []model.Task
 	tx := a.db.WithContext(ctx)
@@ -155,11 +171,41 @@ func (a *task) ListWithUser(ctx context.Context, userId string, opts ...Options)
 	return audits, nil
 }
 
-func (a *task) AssignToAgent(ctx context.Context, taskId int64, agentName string) error {
-	f := a.db.WithContext(ctx).Model(&model.Task{}).Where("id = ?", taskId).Updates(map[string]interface{}{
-		"gmt_modified": time.Now(),
-		"agent_name":   agentName,
+// AssignToAgent 将未分配的任务指派给 agent，通过 resource_version 乐观锁保证多副本下只会被指派一次
+func (a *task) AssignToAgent(ctx context.Context, taskId int64, resourceVersion int64, agentName string) error {
+	f := a.db.WithContext(ctx).Model(&model.Task{}).Where("id = ? and resource_version = ? and agent_name = ?", taskId, resourceVersion, "").Updates(map[string]interface{}{
+		"gmt_modified":     time.Now(),
+		"resource_version": resourceVersion + 1,
+		"agent_name":       agentName,
+		"message":          fmt.Sprintf("Task scheduled to agent %s", agentName),
 	})
+	if f.Error != nil {
+		return f.Error
+	}
+	if f.RowsAffected == 0 {
+		return errors.ErrRecordNotUpdate
+	}
 
-	return f.Error
+	return nil
+}
+
+// CountActiveByAgent 统计每个 agent 上尚未结束的任务数量
+func (a *task) CountActiveByAgent(ctx context.Context) (map[string]int64, error) {
+	var results []struct {
+		AgentName string
+		Count     int64
+	}
+	if err := a.db.WithContext(ctx).Model(&model.Task{}).
+		Select("agent_name, count(*) as count").
+		Where("agent_name != ? and status not in ?", "", model.TaskTerminalStatuses).
+		Group("agent_name").
+		Scan(&results).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	for _, r := range results {
+		counts[r.AgentName] = r.Count
+	}
+	return counts, nil
 }
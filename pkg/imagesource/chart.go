@@ -0,0 +1,66 @@
+package imagesource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+	"k8s.io/klog/v2"
+)
+
+// 渲染 chart 时使用的 release 名称，不影响镜像
+const releaseName = "rainbow"
+
+// Chart 本地的 helm chart，通过 helm template 渲染后提取镜像
+type Chart struct {
+	// chart 目录或者 .tgz 文件
+	Path string `yaml:"path"`
+	// values 文件，按顺序覆盖
+	Values []string `yaml:"values"`
+	// 等同于 helm template --set
+	Set []string `yaml:"set"`
+}
+
+// RenderChart 调用 helm template 渲染 chart，需要节点上安装 helm
+func RenderChart(ctx context.Context, executor exec.Interface, chart Chart) ([]byte, error) {
+	if len(chart.Path) == 0 {
+		return nil, fmt.Errorf("empty chart path")
+	}
+	if _, err := executor.LookPath("helm"); err != nil {
+		return nil, fmt.Errorf("failed to find helm: %v", err)
+	}
+
+	cmd := []string{"helm", "template", releaseName, chart.Path}
+	for _, values := range chart.Values {
+		cmd = append(cmd, "--values", values)
+	}
+	for _, set := range chart.Set {
+		cmd = append(cmd, "--set", set)
+	}
+	klog.Infof("starting render chart %s", chart.Path)
+
+	// helm 的错误信息输出在 stderr 中
+	var stderr bytes.Buffer
+	command := executor.CommandContext(ctx, cmd[0], cmd[1:]...)
+	command.SetStderr(&stderr)
+	out, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render chart %s: %v %s", chart.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// FromChart 渲染 chart 并提取所有容器镜像
+func FromChart(ctx context.Context, executor exec.Interface, chart Chart) ([]string, error) {
+	out, err := RenderChart(ctx, executor, chart)
+	if err != nil {
+		return nil, err
+	}
+	images, err := FromManifests(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rendered chart %s: %v", chart.Path, err)
+	}
+	return images, nil
+}
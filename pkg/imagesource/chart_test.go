@@ -0,0 +1,60 @@
+package imagesource
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+)
+
+// fakeHelm 在 PATH 中放置输出固定内容的 helm 脚本，返回记录命令行参数的文件
+func fakeHelm(t *testing.T, script string) string {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	data := "#!/bin/sh\necho \"$@\" > " + args + "\n" + script
+	if err := ioutil.WriteFile(filepath.Join(dir, "helm"), []byte(data), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return args
+}
+
+func TestFromChart(t *testing.T) {
+	args := fakeHelm(t, "cat <<'EOF'\n"+deployment+"---\n"+cronJob+"EOF\n")
+
+	chart := Chart{Path: "./charts/app", Values: []string{"a.yaml", "b.yaml"}, Set: []string{"image.tag=1.25"}}
+	got, err := FromChart(context.Background(), exec.New(), chart)
+	if err != nil {
+		t.Fatalf("FromChart() error = %v", err)
+	}
+	want := []string{"busybox:1.36", "envoyproxy/envoy:v1.28", "mysql:8.0", "nginx:1.25"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FromChart() = %v, want %v", got, want)
+	}
+
+	data, err := ioutil.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := "template rainbow ./charts/app --values a.yaml --values b.yaml --set image.tag=1.25"
+	if strings.TrimSpace(string(data)) != wantArgs {
+		t.Errorf("helm args = %q, want %q", strings.TrimSpace(string(data)), wantArgs)
+	}
+}
+
+func TestFromChartFailed(t *testing.T) {
+	fakeHelm(t, "echo 'Error: chart not found' >&2\nexit 1\n")
+
+	_, err := FromChart(context.Background(), exec.New(), Chart{Path: "./missing"})
+	if err == nil || !strings.Contains(err.Error(), "chart not found") {
+		t.Errorf("FromChart() error = %v, want helm stderr", err)
+	}
+	if _, err = FromChart(context.Background(), exec.New(), Chart{}); err == nil {
+		t.Errorf("FromChart() with empty path should fail")
+	}
+}
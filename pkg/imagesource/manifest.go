@@ -0,0 +1,113 @@
+package imagesource
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// 包含容器列表的字段，适用于 Pod, Deployment, CronJob 等所有内嵌 PodSpec 的资源
+var containerKeys = sets.NewString("containers", "initContainers", "ephemeralContainers")
+
+// FromManifests 从多文档的 yaml 或 json 中提取所有容器镜像，结果去重并排序
+func FromManifests(data []byte) ([]string, error) {
+	images := sets.NewString()
+	if err := collectManifests(data, images); err != nil {
+		return nil, err
+	}
+	return images.List(), nil
+}
+
+// FromPaths 从 manifest 文件或者目录中提取镜像，目录下只读取 .yaml, .yml 和 .json 文件
+func FromPaths(paths []string) ([]string, error) {
+	images := sets.NewString()
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			// 直接指定的文件不检查扩展名
+			if file != path && !isManifestFile(file) {
+				return nil
+			}
+
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if err = collectManifests(data, images); err != nil {
+				return fmt.Errorf("failed to parse manifest %s: %v", file, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return images.List(), nil
+}
+
+func isManifestFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func collectManifests(data []byte, images sets.String) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		collectImages(doc, images)
+	}
+}
+
+// collectImages 递归查找容器列表，List 类型和自定义资源中内嵌的 PodSpec 也可以识别
+func collectImages(v interface{}, images sets.String) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if containerKeys.Has(key) {
+				addContainerImages(value, images)
+			}
+			collectImages(value, images)
+		}
+	case []interface{}:
+		for _, item := range t {
+			collectImages(item, images)
+		}
+	}
+}
+
+func addContainerImages(v interface{}, images sets.String) {
+	containers, ok := v.([]interface{})
+	if !ok {
+		return
+	}
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if image, ok := container["image"].(string); ok && len(strings.TrimSpace(image)) != 0 {
+			images.Insert(strings.TrimSpace(image))
+		}
+	}
+}
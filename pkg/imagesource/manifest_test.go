@@ -0,0 +1,155 @@
+package imagesource
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.36
+      containers:
+      - name: nginx
+        image: nginx:1.25
+      - name: sidecar
+        image: " envoyproxy/envoy:v1.28 "
+`
+
+const cronJob = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: backup
+            image: mysql:8.0
+`
+
+const list = `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {"kind": "Pod", "spec": {"containers": [{"name": "a", "image": "redis:7.2"}], "ephemeralContainers": [{"name": "debug", "image": "busybox:1.36"}]}},
+    {"kind": "Pod", "spec": {"containers": [{"name": "b", "image": "redis:7.2"}]}}
+  ]
+}`
+
+const nonWorkload = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  image: nginx:1.25
+  containers: nginx
+`
+
+func TestFromManifests(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "deployment", data: deployment, want: []string{"busybox:1.36", "envoyproxy/envoy:v1.28", "nginx:1.25"}},
+		{name: "cronjob", data: cronJob, want: []string{"mysql:8.0"}},
+		{name: "list", data: list, want: []string{"busybox:1.36", "redis:7.2"}},
+		{name: "multiple documents", data: deployment + "---\n" + cronJob, want: []string{"busybox:1.36", "envoyproxy/envoy:v1.28", "mysql:8.0", "nginx:1.25"}},
+		// helm 渲染的结果中经常出现空文档
+		{name: "empty documents", data: "---\n---\n" + cronJob + "---\n# comment only\n", want: []string{"mysql:8.0"}},
+		{name: "empty", data: ""},
+		{name: "non-workload", data: nonWorkload},
+		{name: "container without image", data: "spec:\n  containers:\n  - name: a\n  - image: ''\n"},
+		{name: "invalid yaml", data: "spec: [", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := FromManifests([]byte(tc.data))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("FromManifests() error = %v, want error %v", err, tc.wantErr)
+			}
+			if len(got) == 0 && len(tc.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FromManifests() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFromPaths(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"deployment.yaml":      deployment,
+		"nested/cronjob.yml":   cronJob,
+		"nested/list.json":     list,
+		"nested/configmap.yml": nonWorkload,
+		// 目录下不是 manifest 扩展名的文件被忽略
+		"README.md": "containers:\n- image: ignored:1.0\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "directory",
+			paths: []string{dir},
+			want:  []string{"busybox:1.36", "envoyproxy/envoy:v1.28", "mysql:8.0", "nginx:1.25", "redis:7.2"},
+		},
+		{
+			name:  "files",
+			paths: []string{filepath.Join(dir, "nested", "cronjob.yml"), filepath.Join(dir, "nested", "list.json")},
+			want:  []string{"busybox:1.36", "mysql:8.0", "redis:7.2"},
+		},
+		// 直接指定的文件不检查扩展名
+		{name: "explicit file", paths: []string{filepath.Join(dir, "README.md")}, want: []string{"ignored:1.0"}},
+		{name: "missing path", paths: []string{filepath.Join(dir, "missing")}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := FromPaths(tc.paths)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("FromPaths() error = %v, want error %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FromPaths() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := ioutil.WriteFile(invalid, []byte("spec: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromPaths([]string{dir}); err == nil {
+		t.Errorf("FromPaths() should fail on an invalid manifest")
+	}
+}
@@ -0,0 +1,65 @@
+package kubeimages
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed addons.yaml
+var embeddedAddons []byte
+
+const versionPlaceholder = "{version}"
+
+// Addon 附加组件的镜像，例如 CNI 插件，metrics-server 和 ingress controller
+type Addon struct {
+	// 默认版本
+	Version string   `yaml:"version"`
+	Images  []string `yaml:"images"`
+}
+
+// AddonImages 解析 name 或者 name@version 格式的附加组件，返回组件的所有镜像
+func AddonImages(spec string) ([]string, error) {
+	addons, err := loadAddons()
+	if err != nil {
+		return nil, err
+	}
+
+	name, version := strings.TrimSpace(spec), ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, version = name[:i], name[i+1:]
+	}
+	addon, ok := addons[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown addon %s, supported addons: %s", name, strings.Join(addonNames(addons), ", "))
+	}
+	if len(version) == 0 {
+		version = addon.Version
+	}
+
+	var images []string
+	for _, image := range addon.Images {
+		images = append(images, strings.Replace(image, versionPlaceholder, version, -1))
+	}
+	return images, nil
+}
+
+func loadAddons() (map[string]Addon, error) {
+	var addons map[string]Addon
+	if err := yaml.Unmarshal(embeddedAddons, &addons); err != nil {
+		return nil, fmt.Errorf("failed to parse embedded addons: %v", err)
+	}
+	return addons, nil
+}
+
+func addonNames(addons map[string]Addon) []string {
+	var names []string
+	for name := range addons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,85 @@
+package kubeimages
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
+func TestEmbeddedAddons(t *testing.T) {
+	addons, err := loadAddons()
+	if err != nil {
+		t.Fatalf("loadAddons() error = %v", err)
+	}
+	if len(addons) == 0 {
+		t.Fatalf("no embedded addons")
+	}
+	for name, addon := range addons {
+		if len(addon.Version) == 0 || len(addon.Images) == 0 {
+			t.Errorf("incomplete addon %s: %+v", name, addon)
+			continue
+		}
+		images, err := AddonImages(name)
+		if err != nil {
+			t.Errorf("AddonImages(%s) error = %v", name, err)
+			continue
+		}
+		for _, image := range images {
+			if strings.Contains(image, versionPlaceholder) {
+				t.Errorf("placeholder not replaced in %s", image)
+			}
+			if _, err = registry.ParseReference(image); err != nil {
+				t.Errorf("invalid image %s of addon %s: %v", image, name, err)
+			}
+		}
+	}
+}
+
+func TestAddonImages(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{
+			spec: "calico",
+			want: []string{"docker.io/calico/cni:v3.27.3", "docker.io/calico/node:v3.27.3", "docker.io/calico/kube-controllers:v3.27.3"},
+		},
+		{
+			spec: " calico@v3.28.0 ",
+			want: []string{"docker.io/calico/cni:v3.28.0", "docker.io/calico/node:v3.28.0", "docker.io/calico/kube-controllers:v3.28.0"},
+		},
+		// 不包含 {version} 的镜像不随指定的版本变化
+		{
+			spec: "flannel@v0.26.0",
+			want: []string{"docker.io/flannel/flannel:v0.26.0", "docker.io/flannel/flannel-cni-plugin:v1.4.1-flannel1"},
+		},
+		{spec: "calico@", want: []string{"docker.io/calico/cni:v3.27.3", "docker.io/calico/node:v3.27.3", "docker.io/calico/kube-controllers:v3.27.3"}},
+		{spec: "weave", wantErr: "unknown addon weave"},
+		{spec: "", wantErr: "unknown addon"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			got, err := AddonImages(tc.spec)
+			if len(tc.wantErr) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("AddonImages() error = %v, want %s", err, tc.wantErr)
+				}
+				// 错误信息中列出支持的组件
+				if !strings.Contains(err.Error(), "calico") {
+					t.Errorf("AddonImages() error = %v, want supported addons", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AddonImages() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("AddonImages() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
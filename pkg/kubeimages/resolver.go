@@ -0,0 +1,142 @@
+package kubeimages
+
+import (
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed versions.yaml
+var embeddedVersions []byte
+
+// 控制面组件的镜像 tag 和 kubernetes 版本一致
+var controlPlaneComponents = []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler", "kube-proxy"}
+
+var (
+	// 例如 v1.23.6，不支持 alpha, beta 等预发布版本
+	versionRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)$`)
+	minorRegexp   = regexp.MustCompile(`^v(\d+)\.(\d+)$`)
+)
+
+// ComponentImages 每个 minor 版本的附加组件镜像，不包含仓库地址
+type ComponentImages struct {
+	Pause   string `yaml:"pause"`
+	Etcd    string `yaml:"etcd"`
+	CoreDNS string `yaml:"coredns"`
+}
+
+// VersionTable minor 版本（例如 v1.23）到附加组件镜像的映射
+type VersionTable struct {
+	Repository string                     `yaml:"repository"`
+	Versions   map[string]ComponentImages `yaml:"versions"`
+}
+
+// Resolver 根据版本表计算 kubernetes 控制面的镜像，不依赖 kubeadm 和网络
+type Resolver struct {
+	table VersionTable
+}
+
+// NewResolver 加载内置的版本表，tableFile 不为空时用其中的版本和仓库覆盖内置的配置
+func NewResolver(tableFile string) (*Resolver, error) {
+	var table VersionTable
+	if err := yaml.Unmarshal(embeddedVersions, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse embedded version table: %v", err)
+	}
+	if len(tableFile) == 0 {
+		return &Resolver{table: table}, nil
+	}
+
+	data, err := ioutil.ReadFile(tableFile)
+	if err != nil {
+		return nil, err
+	}
+	var extra VersionTable
+	if err = yaml.Unmarshal(data, &extra); err != nil {
+		return nil, fmt.Errorf("failed to parse version table %s: %v", tableFile, err)
+	}
+	if len(extra.Repository) != 0 {
+		table.Repository = extra.Repository
+	}
+	for minor, images := range extra.Versions {
+		table.Versions[minor] = images
+	}
+	return &Resolver{table: table}, nil
+}
+
+// Supports 判断版本表中是否包含该版本
+func (r *Resolver) Supports(version string) bool {
+	minor, err := minorVersion(version)
+	if err != nil {
+		return false
+	}
+	_, ok := r.table.Versions[minor]
+	return ok
+}
+
+// Images 返回该版本的 apiserver, controller-manager, scheduler, proxy, pause, etcd 和 coredns 镜像，
+// repository 为空时使用版本表中的仓库
+func (r *Resolver) Images(version string, repository string) ([]string, error) {
+	minor, err := minorVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	components, ok := r.table.Versions[minor]
+	if !ok {
+		return nil, fmt.Errorf("kubernetes %s is not in the version table, supported versions: %v", version, r.MinorVersions())
+	}
+	if len(components.Pause) == 0 || len(components.Etcd) == 0 || len(components.CoreDNS) == 0 {
+		return nil, fmt.Errorf("incomplete version table entry for %s", minor)
+	}
+	if len(repository) == 0 {
+		repository = r.table.Repository
+	}
+
+	tag := version
+	if tag[0] != 'v' {
+		tag = "v" + tag
+	}
+	var images []string
+	for _, component := range controlPlaneComponents {
+		images = append(images, fmt.Sprintf("%s/%s:%s", repository, component, tag))
+	}
+	for _, image := range []string{components.CoreDNS, components.Pause, components.Etcd} {
+		images = append(images, repository+"/"+image)
+	}
+	return images, nil
+}
+
+// MinorVersions 版本表中的所有 minor 版本，按版本排序
+func (r *Resolver) MinorVersions() []string {
+	var versions []string
+	for minor := range r.table.Versions {
+		versions = append(versions, minor)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return minorNumber(versions[i]) < minorNumber(versions[j])
+	})
+	return versions
+}
+
+func minorVersion(version string) (string, error) {
+	match := versionRegexp.FindStringSubmatch(version)
+	if match == nil {
+		return "", fmt.Errorf("invalid kubernetes version %q, expected v<major>.<minor>.<patch>", version)
+	}
+	return fmt.Sprintf("v%s.%s", match[1], match[2]), nil
+}
+
+// minorNumber 用于排序，v1.23 -> 1023
+func minorNumber(minor string) int {
+	match := minorRegexp.FindStringSubmatch(minor)
+	if match == nil {
+		return 0
+	}
+	major, _ := strconv.Atoi(match[1])
+	m, _ := strconv.Atoi(match[2])
+	return major*1000 + m
+}
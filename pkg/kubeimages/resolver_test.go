@@ -0,0 +1,211 @@
+package kubeimages
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
+func TestEmbeddedVersionTable(t *testing.T) {
+	r, err := NewResolver("")
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+	if r.table.Repository != "registry.k8s.io" {
+		t.Errorf("repository = %s", r.table.Repository)
+	}
+
+	minors := r.MinorVersions()
+	if len(minors) == 0 || minors[0] != "v1.20" {
+		t.Fatalf("MinorVersions() = %v", minors)
+	}
+	for i, minor := range minors {
+		// 版本表中的 minor 版本连续，新版本追加在最后
+		if want := fmt.Sprintf("v1.%d", 20+i); minor != want {
+			t.Errorf("minor versions not contiguous: got %s, want %s", minor, want)
+		}
+
+		images, err := r.Images(minor+".0", "")
+		if err != nil {
+			t.Errorf("Images(%s) error = %v", minor, err)
+			continue
+		}
+		for _, image := range images {
+			if _, err = registry.ParseReference(image); err != nil {
+				t.Errorf("invalid image %s for %s: %v", image, minor, err)
+			}
+		}
+	}
+}
+
+func TestResolverImages(t *testing.T) {
+	r, err := NewResolver("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version    string
+		repository string
+		want       []string
+		wantErr    string
+	}{
+		{
+			version: "v1.23.6",
+			want: []string{
+				"registry.k8s.io/kube-apiserver:v1.23.6",
+				"registry.k8s.io/kube-controller-manager:v1.23.6",
+				"registry.k8s.io/kube-scheduler:v1.23.6",
+				"registry.k8s.io/kube-proxy:v1.23.6",
+				"registry.k8s.io/coredns/coredns:v1.8.6",
+				"registry.k8s.io/pause:3.6",
+				"registry.k8s.io/etcd:3.5.1-0",
+			},
+		},
+		{
+			version:    "1.20.15",
+			repository: "harbor.example.com/k8s",
+			want: []string{
+				"harbor.example.com/k8s/kube-apiserver:v1.20.15",
+				"harbor.example.com/k8s/kube-controller-manager:v1.20.15",
+				"harbor.example.com/k8s/kube-scheduler:v1.20.15",
+				"harbor.example.com/k8s/kube-proxy:v1.20.15",
+				"harbor.example.com/k8s/coredns:1.7.0",
+				"harbor.example.com/k8s/pause:3.2",
+				"harbor.example.com/k8s/etcd:3.4.13-0",
+			},
+		},
+		{version: "v1.19.16", wantErr: "not in the version table"},
+		{version: "v1.23", wantErr: "invalid kubernetes version"},
+		{version: "v1.29.0-rc.1", wantErr: "invalid kubernetes version"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			got, err := r.Images(tc.version, tc.repository)
+			if len(tc.wantErr) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Images() error = %v, want %s", err, tc.wantErr)
+				}
+				if r.Supports(tc.version) {
+					t.Errorf("Supports(%s) = true", tc.version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Images() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Images() = %v, want %v", got, tc.want)
+			}
+			if !r.Supports(tc.version) {
+				t.Errorf("Supports(%s) = false", tc.version)
+			}
+		})
+	}
+}
+
+func TestMinorVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{version: "v1.23.6", want: "v1.23"},
+		{version: "1.30.0", want: "v1.30"},
+		{version: "v2.0.1", want: "v2.0"},
+		{version: "v1.23", wantErr: true},
+		{version: "v1.23.6-beta.0", wantErr: true},
+		{version: "latest", wantErr: true},
+		{version: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := minorVersion(tc.version)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("minorVersion(%q) error = %v, want error %v", tc.version, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("minorVersion(%q) = %s, want %s", tc.version, got, tc.want)
+		}
+	}
+}
+
+func TestNewResolverOverride(t *testing.T) {
+	write := func(t *testing.T, data string) string {
+		path := filepath.Join(t.TempDir(), "versions.yaml")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("replace and add minors", func(t *testing.T) {
+		r, err := NewResolver(write(t, `repository: harbor.example.com/k8s
+versions:
+  v1.23:
+    pause: pause:3.6-patched
+    etcd: etcd:3.5.1-1
+    coredns: coredns/coredns:v1.8.7
+  v1.100:
+    pause: pause:4.0
+    etcd: etcd:4.0.0-0
+    coredns: coredns/coredns:v2.0.0
+`))
+		if err != nil {
+			t.Fatalf("NewResolver() error = %v", err)
+		}
+
+		images, err := r.Images("v1.23.6", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"harbor.example.com/k8s/coredns/coredns:v1.8.7", "harbor.example.com/k8s/pause:3.6-patched", "harbor.example.com/k8s/etcd:3.5.1-1"}; !reflect.DeepEqual(images[4:], want) {
+			t.Errorf("Images(v1.23.6) = %v, want %v", images[4:], want)
+		}
+		if !r.Supports("v1.100.2") {
+			t.Errorf("added minor v1.100 not supported")
+		}
+		// 未覆盖的版本保持内置的配置
+		if images, err = r.Images("v1.24.0", ""); err != nil || images[5] != "harbor.example.com/k8s/pause:3.7" {
+			t.Errorf("Images(v1.24.0) = %v, %v", images, err)
+		}
+
+		minors := r.MinorVersions()
+		if minors[len(minors)-1] != "v1.100" {
+			t.Errorf("MinorVersions() = %v, want v1.100 last", minors)
+		}
+	})
+
+	t.Run("keep repository", func(t *testing.T) {
+		r, err := NewResolver(write(t, "versions:\n  v1.99:\n    pause: pause:3.10\n"))
+		if err != nil {
+			t.Fatalf("NewResolver() error = %v", err)
+		}
+		if r.table.Repository != "registry.k8s.io" {
+			t.Errorf("repository = %s", r.table.Repository)
+		}
+		// 不完整的版本在使用时报错
+		if _, err = r.Images("v1.99.0", ""); err == nil || !strings.Contains(err.Error(), "incomplete") {
+			t.Errorf("Images() error = %v, want incomplete entry", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := NewResolver(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+			t.Errorf("NewResolver() with a missing file should fail")
+		}
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		if _, err := NewResolver(write(t, "versions: [")); err == nil {
+			t.Errorf("NewResolver() with an invalid file should fail")
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// BlobExists 检查 blob 是否已经存在于仓库中
+func (c *Client) BlobExists(ctx context.Context, host string, repository string, digest string) (bool, error) {
+	resp, err := c.do(ctx, &request{
+		method: http.MethodHead,
+		host:   host,
+		path:   fmt.Sprintf("/v2/%s/blobs/%s", repository, digest),
+		scopes: []string{repositoryScope(repository, "pull,push")},
+	})
+	if err != nil {
+		return false, err
+	}
+	defer drainBody(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check blob %s in %s/%s: %w", digest, host, repository, responseError(resp))
+}
+
+// GetBlob 获取 blob 的内容，调用方负责关闭
+func (c *Client) GetBlob(ctx context.Context, host string, repository string, digest string) (io.ReadCloser, int64, error) {
+	resp, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		host:   host,
+		path:   fmt.Sprintf("/v2/%s/blobs/%s", repository, digest),
+		scopes: []string{repositoryScope(repository, "pull")},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer drainBody(resp)
+		return nil, 0, fmt.Errorf("failed to get blob %s from %s/%s: %w", digest, host, repository, responseError(resp))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// MountBlob 尝试从同一 registry 的其他仓库挂载 blob，registry 不支持挂载时返回上传地址
+func (c *Client) MountBlob(ctx context.Context, host string, repository string, from string, digest string) (bool, string, error) {
+	q := url.Values{}
+	q.Set("mount", digest)
+	q.Set("from", from)
+	return c.startUpload(ctx, host, repository, mountScopes(repository, from), "?"+q.Encode())
+}
+
+// UploadBlob 以单次请求的方式上传 blob，内容直接从 r 流式读取
+func (c *Client) UploadBlob(ctx context.Context, host string, repository string, digest string, size int64, r io.Reader) error {
+	scopes := []string{repositoryScope(repository, "pull,push")}
+	_, location, err := c.startUpload(ctx, host, repository, scopes, "")
+	if err != nil {
+		return err
+	}
+	return c.completeUpload(ctx, host, scopes, location, digest, size, r)
+}
+
+func mountScopes(repository string, from string) []string {
+	return []string{repositoryScope(repository, "pull,push"), repositoryScope(from, "pull")}
+}
+
+func (c *Client) startUpload(ctx context.Context, host string, repository string, scopes []string, query string) (bool, string, error) {
+	resp, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		host:   host,
+		path:   fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, query),
+		scopes: scopes,
+	})
+	if err != nil {
+		return false, "", err
+	}
+	defer drainBody(resp)
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, "", nil
+	case http.StatusAccepted:
+		location, err := c.resolveLocation(host, resp.Header.Get("Location"))
+		return false, location, err
+	}
+	return false, "", fmt.Errorf("failed to start blob upload to %s/%s: %w", host, repository, responseError(resp))
+}
+
+func (c *Client) completeUpload(ctx context.Context, host string, scopes []string, location string, digest string, size int64, r io.Reader) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("digest", digest)
+	u.RawQuery = q.Encode()
+
+	resp, err := c.do(ctx, &request{
+		method: http.MethodPut,
+		host:   host,
+		url:    u.String(),
+		scopes: scopes,
+		header: http.Header{"Content-Type": []string{"application/octet-stream"}},
+		body:   r,
+		size:   size,
+	})
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload blob %s: %w", digest, responseError(resp))
+	}
+	return nil
+}
+
+// resolveLocation 上传地址可能是相对路径
+func (c *Client) resolveLocation(host string, location string) (string, error) {
+	if len(location) == 0 {
+		return "", fmt.Errorf("missing upload location from registry %s", host)
+	}
+	base, err := url.Parse(c.baseURL(host) + "/")
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// countingReader 记录已经读取的字节数，同时更新复制进度
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress *progressTracker
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.progress.addCurrent(int64(n))
+	return n, err
+}
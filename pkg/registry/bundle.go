@@ -0,0 +1,596 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// BundleManifestFile 离线包中记录源镜像，目标镜像和 digest 的文件
+	BundleManifestFile = "rainbow-bundle.json"
+	BundleVersion      = "v1"
+
+	ociLayoutFile        = "oci-layout"
+	ociIndexFile         = "index.json"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// BundleImage 离线包中的镜像，Target 为导出时按照命名规则计算的目标镜像，只用于记录，导入时按照导入环境的命名规则重新计算
+type BundleImage struct {
+	Source    string `json:"source"`
+	Target    string `json:"target,omitempty"`
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
+
+type BundleManifest struct {
+	Version string        `json:"version"`
+	Images  []BundleImage `json:"images"`
+}
+
+// BundleWriter 将镜像写入 OCI image layout 格式的 tar 包。
+// 包中依次为 oci-layout, index.json, rainbow-bundle.json, 所有的 manifest 和其余的 blob，导入时只需要顺序读取一次
+type BundleWriter struct {
+	client    *Client
+	platforms []Platform
+
+	images    []BundleImage
+	manifests []*RawManifest
+	blobs     []bundleBlob
+	written   map[string]bool
+}
+
+// bundleBlob 记录 blob 所在的源仓库，写入时从源仓库读取
+type bundleBlob struct {
+	src  Reference
+	desc Descriptor
+}
+
+// NewBundleWriter platforms 为空时导出镜像的所有平台
+func (c *Client) NewBundleWriter(platforms []Platform) *BundleWriter {
+	return &BundleWriter{client: c, platforms: platforms, written: make(map[string]bool)}
+}
+
+// Add 解析源镜像的 manifest 并加入离线包，返回写入离线包的镜像信息，失败时不影响已经加入的镜像
+func (b *BundleWriter) Add(ctx context.Context, source string, target string) (BundleImage, error) {
+	image := BundleImage{Source: source, Target: target}
+
+	src, err := ParseReference(source)
+	if err != nil {
+		return image, err
+	}
+	manifest, err := b.client.GetManifest(ctx, src.Registry, src.Repository, src.Identifier())
+	if err != nil {
+		return image, err
+	}
+
+	var (
+		manifests = []*RawManifest{}
+		blobs     []bundleBlob
+	)
+	if IsIndex(manifest.MediaType) {
+		children, index, err := filterIndex(src, manifest, b.platforms)
+		if err != nil {
+			return image, err
+		}
+		manifest = index
+		manifests = append(manifests, index)
+		for _, desc := range children {
+			child, err := b.client.GetManifest(ctx, src.Registry, src.Repository, desc.Digest)
+			if err != nil {
+				return image, err
+			}
+			childBlobs, err := imageBlobs(src, child)
+			if err != nil {
+				return image, err
+			}
+			manifests = append(manifests, child)
+			blobs = append(blobs, childBlobs...)
+		}
+	} else {
+		if len(b.platforms) != 0 {
+			if err = b.client.checkImagePlatform(ctx, src, manifest, b.platforms); err != nil {
+				return image, err
+			}
+		}
+		if blobs, err = imageBlobs(src, manifest); err != nil {
+			return image, err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	image.Digest, image.MediaType, image.Size = manifest.Digest, manifest.MediaType, int64(len(manifest.Body))
+	b.images = append(b.images, image)
+	for _, m := range manifests {
+		if !b.written[m.Digest] {
+			b.written[m.Digest] = true
+			b.manifests = append(b.manifests, m)
+		}
+	}
+	for _, blob := range blobs {
+		if !b.written[blob.desc.Digest] {
+			b.written[blob.desc.Digest] = true
+			b.blobs = append(b.blobs, blob)
+		}
+	}
+	return image, nil
+}
+
+func imageBlobs(src Reference, manifest *RawManifest) ([]bundleBlob, error) {
+	image, err := manifest.Parse()
+	if err != nil {
+		return nil, err
+	}
+	if IsIndex(image.MediaType) || len(image.Manifests) != 0 {
+		return nil, fmt.Errorf("nested index %s is not supported", manifest.Digest)
+	}
+
+	var blobs []bundleBlob
+	for _, desc := range append([]Descriptor{image.Config}, image.Layers...) {
+		// 外部镜像层不存储在 registry 中，导入时也不需要
+		if strings.Contains(desc.MediaType, "foreign") || len(desc.URLs) != 0 {
+			continue
+		}
+		blobs = append(blobs, bundleBlob{src: src, desc: desc})
+	}
+	return blobs, nil
+}
+
+// Images 已经加入离线包的镜像
+func (b *BundleWriter) Images() []BundleImage {
+	return b.images
+}
+
+// WriteTo 写入 tar 包，镜像层从源仓库流式读取并校验 digest
+func (b *BundleWriter) WriteTo(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	layout, _ := json.Marshal(map[string]string{"imageLayoutVersion": "1.0.0"})
+	if err := writeTarFile(tw, ociLayoutFile, layout); err != nil {
+		return err
+	}
+
+	index := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Manifests     []Descriptor `json:"manifests"`
+	}{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	for _, image := range b.images {
+		index.Manifests = append(index.Manifests, Descriptor{
+			MediaType:   image.MediaType,
+			Digest:      image.Digest,
+			Size:        image.Size,
+			Annotations: map[string]string{ociRefNameAnnotation: image.Source},
+		})
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err = writeTarFile(tw, ociIndexFile, data); err != nil {
+		return err
+	}
+
+	if data, err = json.MarshalIndent(BundleManifest{Version: BundleVersion, Images: b.images}, "", "  "); err != nil {
+		return err
+	}
+	if err = writeTarFile(tw, BundleManifestFile, data); err != nil {
+		return err
+	}
+
+	for _, manifest := range b.manifests {
+		if err = writeTarFile(tw, blobPath(manifest.Digest), manifest.Body); err != nil {
+			return err
+		}
+	}
+	for _, blob := range b.blobs {
+		if err = b.writeBlob(ctx, tw, blob); err != nil {
+			return fmt.Errorf("failed to write blob %s: %w", blob.desc.Digest, err)
+		}
+	}
+	return tw.Close()
+}
+
+func (b *BundleWriter) writeBlob(ctx context.Context, tw *tar.Writer, blob bundleBlob) error {
+	body, _, err := b.client.GetBlob(ctx, blob.src.Registry, blob.src.Repository, blob.desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err = tw.WriteHeader(tarHeader(blobPath(blob.desc.Digest), blob.desc.Size)); err != nil {
+		return err
+	}
+	verifier := newDigestVerifier(blob.desc.Digest)
+	n, err := io.Copy(tw, io.TeeReader(body, verifier))
+	if err != nil {
+		return err
+	}
+	if n != blob.desc.Size {
+		return fmt.Errorf("blob size mismatch, expected %d got %d", blob.desc.Size, n)
+	}
+	return verifier.verify()
+}
+
+func blobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// blobDigest 从 blobs/<algorithm>/<hex> 中解析 digest
+func blobDigest(name string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path.Clean(name), "./"), "/")
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return "", false
+	}
+	return parts[1] + ":" + parts[2], true
+}
+
+func tarHeader(name string, size int64) *tar.Header {
+	// 固定修改时间，相同的镜像导出的离线包内容一致
+	return &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Unix(0, 0), Typeflag: tar.TypeReg}
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(tarHeader(name, int64(len(data)))); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// digestVerifier 只校验 sha256 的 digest，其他算法跳过校验
+type digestVerifier struct {
+	digest string
+	hash   hash.Hash
+}
+
+func newDigestVerifier(digest string) *digestVerifier {
+	v := &digestVerifier{digest: digest}
+	if strings.HasPrefix(digest, "sha256:") {
+		v.hash = sha256.New()
+	}
+	return v
+}
+
+func (v *digestVerifier) Write(p []byte) (int, error) {
+	if v.hash == nil {
+		return len(p), nil
+	}
+	return v.hash.Write(p)
+}
+
+func (v *digestVerifier) verify() error {
+	if v.hash == nil {
+		return nil
+	}
+	if actual := "sha256:" + hex.EncodeToString(v.hash.Sum(nil)); actual != v.digest {
+		return fmt.Errorf("digest mismatch, expected %s got %s", v.digest, actual)
+	}
+	return nil
+}
+
+// ImportResult 单个镜像的导入结果
+type ImportResult struct {
+	Source string
+	Target string
+	Digest string
+	Err    error
+}
+
+// TargetFunc 根据源镜像计算导入的目标镜像
+type TargetFunc func(source string) (string, error)
+
+// bundleImport 导入过程中单个镜像的状态
+type bundleImport struct {
+	image BundleImage
+	dst   Reference
+	err   error
+	// 需要推送的 manifest，子镜像在前
+	manifests []*RawManifest
+}
+
+// ImportBundle 顺序读取离线包并推送到目标仓库，单个镜像失败不影响其他镜像，离线包格式错误时返回错误
+func (c *Client) ImportBundle(ctx context.Context, r io.Reader, target TargetFunc) ([]ImportResult, error) {
+	tr := tar.NewReader(r)
+
+	var (
+		imports []*bundleImport
+		// manifest 和 blob 的 digest 到引用它的镜像的映射
+		manifestOwners = make(map[string][]*bundleImport)
+		blobOwners     = make(map[string][]*bundleImport)
+		manifests      = make(map[string]*RawManifest)
+		// manifest 的 mediaType 来自 rainbow-bundle.json 和 index 中的描述符，OCI manifest 中的 mediaType 字段是可选的
+		mediaTypes = make(map[string]string)
+		seenBundle bool
+	)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if name == BundleManifestFile {
+			if imports, err = c.readBundleManifest(tr, target); err != nil {
+				return nil, err
+			}
+			for _, i := range imports {
+				if i.err == nil {
+					manifestOwners[i.image.Digest] = append(manifestOwners[i.image.Digest], i)
+					mediaTypes[i.image.Digest] = i.image.MediaType
+				}
+			}
+			seenBundle = true
+			continue
+		}
+
+		digest, ok := blobDigest(name)
+		if !ok {
+			continue
+		}
+		if !seenBundle {
+			return nil, fmt.Errorf("%s must precede blobs in the bundle", BundleManifestFile)
+		}
+
+		if owners, ok := manifestOwners[digest]; ok {
+			manifest, err := readBundleManifestBlob(tr, digest, hdr.Size, mediaTypes[digest])
+			if err != nil {
+				failImports(owners, err)
+				continue
+			}
+			manifests[digest] = manifest
+			if err = collectReferences(manifest, owners, manifests, manifestOwners, blobOwners, mediaTypes); err != nil {
+				failImports(owners, err)
+			}
+			continue
+		}
+
+		if owners := activeImports(blobOwners[digest]); len(owners) != 0 {
+			if err = c.importBlob(ctx, tr, digest, hdr.Size, owners); err != nil {
+				failImports(owners, fmt.Errorf("failed to import blob %s: %w", digest, err))
+			}
+			delete(blobOwners, digest)
+		}
+	}
+	if !seenBundle {
+		return nil, fmt.Errorf("%s not found, not a rainbow bundle", BundleManifestFile)
+	}
+
+	// 离线包中缺少的 blob 会导致引用它的镜像失败
+	for digest, owners := range blobOwners {
+		failImports(owners, fmt.Errorf("blob %s not found in bundle", digest))
+	}
+
+	var results []ImportResult
+	for _, i := range imports {
+		if i.err == nil {
+			i.err = c.pushBundleManifests(ctx, i, manifests)
+		}
+		results = append(results, ImportResult{Source: i.image.Source, Target: i.dst.String(), Digest: i.image.Digest, Err: i.err})
+	}
+	return results, nil
+}
+
+func (c *Client) readBundleManifest(r io.Reader, target TargetFunc) ([]*bundleImport, error) {
+	var bundle BundleManifest
+	if err := json.NewDecoder(&limitedReader{r: r, n: maxManifestSize}).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", BundleManifestFile, err)
+	}
+	if bundle.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %s", bundle.Version)
+	}
+
+	var imports []*bundleImport
+	for _, image := range bundle.Images {
+		i := &bundleImport{image: image}
+		imports = append(imports, i)
+
+		t, err := target(image.Source)
+		if err != nil {
+			i.err = err
+			continue
+		}
+		i.dst, i.err = ParseReference(t)
+	}
+	return imports, nil
+}
+
+// readBundleManifestBlob mediaType 为引用该 manifest 的描述符中的类型，为空时使用 manifest 中的 mediaType 字段
+func readBundleManifestBlob(r io.Reader, digest string, size int64, mediaType string) (*RawManifest, error) {
+	if size > maxManifestSize {
+		return nil, fmt.Errorf("manifest %s exceeds %d bytes", digest, maxManifestSize)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if actual := Digest(body); actual != digest {
+		return nil, fmt.Errorf("manifest digest mismatch, expected %s got %s", digest, actual)
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %v", digest, err)
+	}
+	if len(mediaType) == 0 {
+		mediaType = m.MediaType
+	}
+	// 推送时 registry 要求 Content-Type 为 manifest 的类型
+	if len(mediaType) == 0 {
+		return nil, fmt.Errorf("unknown media type of manifest %s", digest)
+	}
+	return &RawManifest{MediaType: mediaType, Digest: digest, Body: body}, nil
+}
+
+// collectReferences 记录 manifest 引用的子镜像和 blob 属于哪些镜像。
+// 子镜像的 manifest 一般在 index 之后，但同时作为独立镜像导出时可能已经读取过
+func collectReferences(manifest *RawManifest, owners []*bundleImport, manifests map[string]*RawManifest, manifestOwners map[string][]*bundleImport, blobOwners map[string][]*bundleImport, mediaTypes map[string]string) error {
+	image, err := manifest.Parse()
+	if err != nil {
+		return err
+	}
+
+	// 子镜像需要先于 index 推送
+	for _, owner := range owners {
+		owner.manifests = append([]*RawManifest{manifest}, owner.manifests...)
+	}
+
+	if IsIndex(manifest.MediaType) || len(image.Manifests) != 0 {
+		for _, desc := range image.Manifests {
+			manifestOwners[desc.Digest] = append(manifestOwners[desc.Digest], owners...)
+			if len(desc.MediaType) != 0 {
+				mediaTypes[desc.Digest] = desc.MediaType
+			}
+			if child, ok := manifests[desc.Digest]; ok {
+				if IsIndex(child.MediaType) {
+					return fmt.Errorf("nested index %s is not supported", child.Digest)
+				}
+				if err = collectReferences(child, owners, manifests, manifestOwners, blobOwners, mediaTypes); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		for _, desc := range append([]Descriptor{image.Config}, image.Layers...) {
+			if strings.Contains(desc.MediaType, "foreign") || len(desc.URLs) != 0 {
+				continue
+			}
+			blobOwners[desc.Digest] = append(blobOwners[desc.Digest], owners...)
+		}
+	}
+	return nil
+}
+
+// importBlob 只有一个目标仓库时直接流式上传，多个目标仓库时先写入临时文件，之后的仓库优先跨仓库挂载
+func (c *Client) importBlob(ctx context.Context, r io.Reader, digest string, size int64, owners []*bundleImport) error {
+	var repos []Reference
+	seen := make(map[string]bool)
+	for _, owner := range owners {
+		key := owner.dst.Registry + "/" + owner.dst.Repository
+		if !seen[key] {
+			seen[key] = true
+			repos = append(repos, owner.dst)
+		}
+	}
+
+	if len(repos) == 1 {
+		return c.importBlobTo(ctx, repos[0], digest, size, func() (io.Reader, error) { return r, nil })
+	}
+
+	f, err := ioutil.TempFile("", "rainbow-blob-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = io.Copy(f, r); err != nil {
+		return err
+	}
+
+	var from *Reference
+	for i := range repos {
+		dst := repos[i]
+		if from != nil && from.Registry == dst.Registry {
+			mounted, _, err := c.MountBlob(ctx, dst.Registry, dst.Repository, from.Repository, digest)
+			if err == nil && mounted {
+				continue
+			}
+		}
+		err = c.importBlobTo(ctx, dst, digest, size, func() (io.Reader, error) {
+			_, err := f.Seek(0, io.SeekStart)
+			return f, err
+		})
+		if err != nil {
+			return err
+		}
+		from = &dst
+	}
+	return nil
+}
+
+func (c *Client) importBlobTo(ctx context.Context, dst Reference, digest string, size int64, open func() (io.Reader, error)) error {
+	exists, err := c.BlobExists(ctx, dst.Registry, dst.Repository, digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		klog.V(2).Infof("blob %s already exists in %s", digest, dst.Repository)
+		return nil
+	}
+
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	return c.UploadBlob(ctx, dst.Registry, dst.Repository, digest, size, r)
+}
+
+// pushBundleManifests 推送子镜像和镜像的 manifest 并校验目标镜像的 digest
+func (c *Client) pushBundleManifests(ctx context.Context, i *bundleImport, manifests map[string]*RawManifest) error {
+	if len(i.manifests) == 0 {
+		return fmt.Errorf("manifest %s not found in bundle", i.image.Digest)
+	}
+	for _, m := range i.manifests {
+		if _, ok := manifests[m.Digest]; !ok {
+			return fmt.Errorf("manifest %s not found in bundle", m.Digest)
+		}
+		// 最后一个为镜像本身的 manifest，使用目标镜像的 tag 推送
+		reference := m.Digest
+		if m.Digest == i.image.Digest && len(i.dst.Tag) != 0 {
+			reference = i.dst.Tag
+		}
+		if err := c.PutManifest(ctx, i.dst.Registry, i.dst.Repository, reference, m); err != nil {
+			return err
+		}
+	}
+
+	digest, err := c.ManifestDigest(ctx, i.dst.Registry, i.dst.Repository, i.dst.Identifier())
+	if err != nil {
+		return err
+	}
+	if digest != i.image.Digest {
+		return fmt.Errorf("target digest %s does not match bundle digest %s", digest, i.image.Digest)
+	}
+	return nil
+}
+
+func activeImports(imports []*bundleImport) []*bundleImport {
+	var active []*bundleImport
+	for _, i := range imports {
+		if i.err == nil {
+			active = append(active, i)
+		}
+	}
+	return active
+}
+
+func failImports(imports []*bundleImport, err error) {
+	for _, i := range imports {
+		if i.err == nil {
+			i.err = err
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// addUntypedIndex 添加 manifest 和 index 中都没有 mediaType 字段的多平台镜像，类型只通过 Content-Type 和描述符给出
+func (f *fakeRegistry) addUntypedIndex(repository string, tag string) RawManifest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var entries []string
+	for _, arch := range []string{"amd64", "arm64"} {
+		config := []byte(fmt.Sprintf(`{"os":"linux","architecture":%q}`, arch))
+		layer := []byte("layer-" + arch)
+		f.blobs[repository+"@"+Digest(config)] = config
+		f.blobs[repository+"@"+Digest(layer)] = layer
+
+		body := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":%d},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":%q,"size":%d}]}`,
+			Digest(config), len(config), Digest(layer), len(layer)))
+		child := RawManifest{MediaType: MediaTypeOCIManifest, Digest: Digest(body), Body: body}
+		f.manifests[repository+":"+child.Digest] = child
+		entries = append(entries, fmt.Sprintf(`{"mediaType":%q,"digest":%q,"size":%d,"platform":{"os":"linux","architecture":%q}}`,
+			MediaTypeOCIManifest, child.Digest, len(body), arch))
+	}
+
+	body := []byte(`{"schemaVersion":2,"manifests":[` + strings.Join(entries, ",") + `]}`)
+	m := RawManifest{MediaType: MediaTypeOCIIndex, Digest: Digest(body), Body: body}
+	f.manifests[repository+":"+tag] = m
+	f.manifests[repository+":"+m.Digest] = m
+	return m
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		add       func(f *fakeRegistry) RawManifest
+		platforms []Platform
+	}{
+		{
+			name: "image",
+			add: func(f *fakeRegistry) RawManifest {
+				return f.addImage("library/nginx", "1.25", Platform{OS: "linux", Architecture: "amd64"})
+			},
+		},
+		{
+			name: "index",
+			add:  func(f *fakeRegistry) RawManifest { return f.addIndex("library/nginx", "1.25") },
+		},
+		// OCI manifest 和 index 中的 mediaType 字段是可选的
+		{
+			name: "index without media types",
+			add:  func(f *fakeRegistry) RawManifest { return f.addUntypedIndex("library/nginx", "1.25") },
+		},
+		{
+			name:      "filtered index without media types",
+			add:       func(f *fakeRegistry) RawManifest { return f.addUntypedIndex("library/nginx", "1.25") },
+			platforms: []Platform{{OS: "linux", Architecture: "arm64"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src, dst := newFakeRegistry(t), newFakeRegistry(t)
+			tc.add(src)
+			c := NewClient()
+			ctx := context.Background()
+
+			writer := c.NewBundleWriter(tc.platforms)
+			image, err := writer.Add(ctx, src.host()+"/library/nginx:1.25", "")
+			if err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err = writer.WriteTo(ctx, &buf); err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+
+			results, err := c.ImportBundle(ctx, &buf, func(source string) (string, error) {
+				return dst.host() + "/mirror/nginx:1.25", nil
+			})
+			if err != nil {
+				t.Fatalf("ImportBundle() error = %v", err)
+			}
+			if len(results) != 1 || results[0].Err != nil {
+				t.Fatalf("ImportBundle() results = %+v", results)
+			}
+
+			pushed, ok := dst.manifests["mirror/nginx:1.25"]
+			if !ok {
+				t.Fatalf("manifest not pushed to target")
+			}
+			if pushed.Digest != image.Digest || pushed.MediaType != image.MediaType {
+				t.Errorf("pushed manifest %s %s, want %s %s", pushed.Digest, pushed.MediaType, image.Digest, image.MediaType)
+			}
+			var index Manifest
+			if err = json.Unmarshal(pushed.Body, &index); err != nil {
+				t.Fatal(err)
+			}
+			for _, desc := range index.Manifests {
+				child, ok := dst.manifests["mirror/nginx:"+desc.Digest]
+				if !ok {
+					t.Errorf("child manifest %s not pushed", desc.Digest)
+					continue
+				}
+				if child.MediaType != desc.MediaType {
+					t.Errorf("child manifest %s pushed as %q, want %q", desc.Digest, child.MediaType, desc.MediaType)
+				}
+			}
+		})
+	}
+}
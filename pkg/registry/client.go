@@ -0,0 +1,402 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 使用 identity token 获取访问 token 时的 client_id
+const oauthClientId = "rainbow"
+
+// Credential registry 的认证信息，支持用户名密码（包括 robot 账号）和 token
+type Credential struct {
+	Username string
+	Password string
+	// IdentityToken 用于通过 OAuth2 refresh_token 方式获取访问 token，例如 credential helper 返回的 <token> 用户
+	IdentityToken string
+	// RegistryToken 直接作为 Bearer token 访问 registry
+	RegistryToken string
+}
+
+func (c Credential) IsEmpty() bool {
+	return len(c.Username) == 0 && len(c.Password) == 0 && len(c.IdentityToken) == 0 && len(c.RegistryToken) == 0
+}
+
+// String 只输出用户名，避免认证信息出现在日志中
+func (c Credential) String() string {
+	switch {
+	case len(c.RegistryToken) != 0:
+		return "registry token"
+	case len(c.IdentityToken) != 0:
+		return "identity token"
+	case len(c.Username) != 0:
+		return "user " + c.Username
+	}
+	return "anonymous"
+}
+
+// Client 基于 OCI distribution API 的 registry 客户端，无需 docker daemon
+type Client struct {
+	client      *http.Client
+	credentials map[string]Credential
+	insecure    map[string]bool
+
+	lock sync.Mutex
+	// 按照 registry 和 scope 缓存的认证头
+	authorizations map[string]string
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithCredential 设置访问指定 registry 时使用的用户名和密码
+func WithCredential(host string, username string, password string) Option {
+	return WithAuth(host, Credential{Username: username, Password: password})
+}
+
+// WithAuth 设置访问指定 registry 时使用的认证信息，认证信息为空时匿名访问
+func WithAuth(host string, cred Credential) Option {
+	return func(c *Client) {
+		if cred.IsEmpty() {
+			return
+		}
+		c.credentials[host] = cred
+	}
+}
+
+// WithInsecure 指定的 registry 使用 http 访问
+func WithInsecure(hosts ...string) Option {
+	return func(c *Client) {
+		for _, host := range hosts {
+			c.insecure[host] = true
+		}
+	}
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		// 镜像层的传输时间不可预期，超时由调用方通过 ctx 控制
+		client:         &http.Client{},
+		credentials:    make(map[string]Credential),
+		insecure:       make(map[string]bool),
+		authorizations: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// baseURL 获取 registry 的 API 地址，和 docker 一致 localhost 默认使用 http
+func (c *Client) baseURL(host string) string {
+	if host == DefaultRegistry {
+		host = dockerHubEndpoint
+	}
+
+	scheme := "https"
+	hostname := strings.Split(host, ":")[0]
+	if c.insecure[host] || hostname == "localhost" || hostname == "127.0.0.1" {
+		scheme = "http"
+	}
+	return scheme + "://" + host
+}
+
+func repositoryScope(repository string, actions string) string {
+	return fmt.Sprintf("repository:%s:%s", repository, actions)
+}
+
+func authorizationKey(host string, scopes []string) string {
+	sorted := append([]string{}, scopes...)
+	sort.Strings(sorted)
+	return host + "|" + strings.Join(sorted, " ")
+}
+
+// request 描述一次 registry API 请求，url 为空时使用 registry 地址拼接 path
+type request struct {
+	method string
+	host   string
+	path   string
+	url    string
+	scopes []string
+	header http.Header
+
+	// content 在认证后可以重放，流式的 body 需要在发送前完成认证
+	content []byte
+	body    io.Reader
+	size    int64
+}
+
+func (c *Client) newHTTPRequest(ctx context.Context, r *request) (*http.Request, error) {
+	u := r.url
+	if len(u) == 0 {
+		u = c.baseURL(r.host) + r.path
+	}
+	body, size := r.body, r.size
+	if r.content != nil {
+		body, size = bytes.NewReader(r.content), int64(len(r.content))
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	c.lock.Lock()
+	authorization := c.authorizations[authorizationKey(r.host, r.scopes)]
+	c.lock.Unlock()
+	if len(authorization) != 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	return req, nil
+}
+
+// do 发送请求，registry 要求认证时获取 token 后重试
+func (c *Client) do(ctx context.Context, r *request) (*http.Response, error) {
+	req, err := c.newHTTPRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	drainBody(resp)
+	if r.body != nil {
+		return nil, fmt.Errorf("%s %s unauthorized", r.method, req.URL.Path)
+	}
+	if err = c.authorize(ctx, r.host, r.scopes, challenge); err != nil {
+		return nil, err
+	}
+
+	if req, err = c.newHTTPRequest(ctx, r); err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// Ping 检查 registry 是否可以访问，配置了认证信息时同时检查认证信息是否有效
+func (c *Client) Ping(ctx context.Context, host string) error {
+	resp, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		host:   host,
+		path:   "/v2/",
+	})
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to ping registry %s: %w", host, responseError(resp))
+	}
+	return nil
+}
+
+// authorize 根据 registry 返回的认证要求获取认证头，支持 Basic 和 Bearer token
+func (c *Client) authorize(ctx context.Context, host string, scopes []string, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	cred, hasCred := c.credentials[host]
+
+	var authorization string
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCred || len(cred.Username) == 0 {
+			return fmt.Errorf("registry %s requires username and password", host)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(cred.Username, cred.Password)
+		authorization = req.Header.Get("Authorization")
+	case "bearer":
+		if len(cred.RegistryToken) != 0 {
+			authorization = "Bearer " + cred.RegistryToken
+			break
+		}
+		token, err := c.fetchToken(ctx, params, scopes, cred)
+		if err != nil {
+			return fmt.Errorf("failed to get token from registry %s: %w", host, err)
+		}
+		authorization = "Bearer " + token
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q from registry %s", challenge, host)
+	}
+
+	c.lock.Lock()
+	c.authorizations[authorizationKey(host, scopes)] = authorization
+	c.lock.Unlock()
+	return nil
+}
+
+// fetchToken 配置了 identity token 时使用 OAuth2 refresh_token 方式获取 token，否则使用用户名密码或者匿名获取
+func (c *Client) fetchToken(ctx context.Context, params map[string]string, scopes []string, cred Credential) (string, error) {
+	realm := params["realm"]
+	if len(realm) == 0 {
+		return "", fmt.Errorf("missing realm in bearer challenge")
+	}
+	if len(cred.IdentityToken) != 0 {
+		return c.fetchOAuthToken(ctx, realm, params["service"], scopes, cred)
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if service := params["service"]; len(service) != 0 {
+		q.Set("service", service)
+	}
+	for _, scope := range scopes {
+		q.Add("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if len(cred.Username) != 0 {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	return c.doTokenRequest(req)
+}
+
+func (c *Client) fetchOAuthToken(ctx context.Context, realm string, service string, scopes []string, cred Credential) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", cred.IdentityToken)
+	form.Set("client_id", oauthClientId)
+	if len(service) != 0 {
+		form.Set("service", service)
+	}
+	if len(scopes) != 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.doTokenRequest(req)
+}
+
+func (c *Client) doTokenRequest(req *http.Request) (string, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if len(token.Token) != 0 {
+		return token.Token, nil
+	}
+	if len(token.AccessToken) != 0 {
+		return token.AccessToken, nil
+	}
+	return "", fmt.Errorf("empty token")
+}
+
+// parseChallenge 解析 WWW-Authenticate，例如 Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	challenge = strings.TrimSpace(challenge)
+	i := strings.Index(challenge, " ")
+	if i < 0 {
+		return challenge, params
+	}
+	scheme, rest := challenge[:i], challenge[i+1:]
+
+	for len(rest) != 0 {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end:]
+			}
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+// StatusError registry 返回的非预期状态码，Codes 为 distribution 错误响应中的错误码，例如 MANIFEST_UNKNOWN
+type StatusError struct {
+	StatusCode int
+	Message    string
+	Codes      []string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("registry responded %d: %s", e.StatusCode, e.Message)
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	var errs struct {
+		Errors []struct {
+			Code string `json:"code"`
+		} `json:"errors"`
+	}
+	var codes []string
+	if err := json.Unmarshal(body, &errs); err == nil {
+		for _, e := range errs.Errors {
+			codes = append(codes, e.Code)
+		}
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body)), Codes: codes}
+}
+
+func drainBody(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+}
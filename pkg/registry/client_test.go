@@ -0,0 +1,284 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const fakeToken = "fake-token"
+
+// fakeRegistry 基于 httptest 的 OCI distribution registry，所有 API 都要求通过 /token 获取的 Bearer token
+type fakeRegistry struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	lock      sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]RawManifest
+	// 为 true 时不支持跨仓库挂载，返回上传地址
+	noMount bool
+
+	mounts  int
+	uploads int
+	// 每次获取 token 时请求的 scope 和认证头
+	tokenScopes []string
+	tokenAuth   []string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	f := &fakeRegistry{
+		t:         t,
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]RawManifest),
+	}
+	f.srv = httptest.NewServer(f)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// host 为 127.0.0.1:port，客户端使用 http 访问
+func (f *fakeRegistry) host() string {
+	return strings.TrimPrefix(f.srv.URL, "http://")
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Path == "/token" {
+		f.tokenScopes = append(f.tokenScopes, strings.Join(r.URL.Query()["scope"], " "))
+		f.tokenAuth = append(f.tokenAuth, r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": fakeToken})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, f.srv.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(p, "/tags/list"):
+		f.serveTags(w, r, strings.TrimSuffix(p, "/tags/list"))
+	case strings.Contains(p, "/manifests/"):
+		i := strings.Index(p, "/manifests/")
+		f.serveManifest(w, r, p[:i], p[i+len("/manifests/"):])
+	case strings.Contains(p, "/blobs/uploads/"):
+		f.serveUpload(w, r, p[:strings.Index(p, "/blobs/uploads/")])
+	case strings.Contains(p, "/blobs/"):
+		i := strings.Index(p, "/blobs/")
+		blob, ok := f.blobs[p[:i]+"@"+p[i+len("/blobs/"):]]
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(blob)
+		}
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (f *fakeRegistry) serveTags(w http.ResponseWriter, r *http.Request, repository string) {
+	var tags []string
+	for key := range f.manifests {
+		if strings.HasPrefix(key, repository+":") && !strings.HasPrefix(key, repository+":sha256:") {
+			tags = append(tags, strings.TrimPrefix(key, repository+":"))
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": tags})
+}
+
+func (f *fakeRegistry) serveManifest(w http.ResponseWriter, r *http.Request, repository string, reference string) {
+	if r.Method == http.MethodPut {
+		// 和 distribution 一致，按照 Content-Type 解析 manifest
+		if len(r.Header.Get("Content-Type")) == 0 {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID")
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		m := RawManifest{MediaType: r.Header.Get("Content-Type"), Digest: Digest(body), Body: body}
+		f.manifests[repository+":"+reference] = m
+		f.manifests[repository+":"+m.Digest] = m
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	m, ok := f.manifests[repository+":"+reference]
+	if !ok {
+		writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN")
+		return
+	}
+	w.Header().Set("Content-Type", m.MediaType)
+	w.Header().Set("Docker-Content-Digest", m.Digest)
+	w.Header().Set("Content-Length", fmt.Sprint(len(m.Body)))
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(m.Body)
+	}
+}
+
+func (f *fakeRegistry) serveUpload(w http.ResponseWriter, r *http.Request, repository string) {
+	if r.Method == http.MethodPost {
+		q := r.URL.Query()
+		if digest := q.Get("mount"); len(digest) != 0 && !f.noMount {
+			if blob, ok := f.blobs[q.Get("from")+"@"+digest]; ok {
+				f.blobs[repository+"@"+digest] = blob
+				f.mounts++
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/session?state=fake")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// registry 校验上传内容的 digest
+	body, _ := ioutil.ReadAll(r.Body)
+	digest := r.URL.Query().Get("digest")
+	if Digest(body) != digest {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID")
+		return
+	}
+	f.uploads++
+	f.blobs[repository+"@"+digest] = body
+	w.WriteHeader(http.StatusCreated)
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": strings.ToLower(code)}},
+	})
+}
+
+// addImage 添加单平台镜像，tag 为空时只能通过 digest 访问
+func (f *fakeRegistry) addImage(repository string, tag string, platform Platform) RawManifest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	config := []byte(fmt.Sprintf(`{"os":%q,"architecture":%q}`, platform.OS, platform.Architecture))
+	layer := []byte("layer-" + platform.String() + "-" + tag)
+	f.blobs[repository+"@"+Digest(config)] = config
+	f.blobs[repository+"@"+Digest(layer)] = layer
+
+	body, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: Digest(config), Size: int64(len(config))},
+		Layers:        []Descriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: Digest(layer), Size: int64(len(layer))}},
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	m := RawManifest{MediaType: MediaTypeOCIManifest, Digest: Digest(body), Body: body}
+	if len(tag) != 0 {
+		f.manifests[repository+":"+tag] = m
+	}
+	f.manifests[repository+":"+m.Digest] = m
+	return m
+}
+
+// addIndex 添加包含 linux/amd64 和 linux/arm64 的多平台镜像
+func (f *fakeRegistry) addIndex(repository string, tag string) RawManifest {
+	var descs []Descriptor
+	for _, platform := range []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}} {
+		platform := platform
+		child := f.addImage(repository, "", platform)
+		descs = append(descs, Descriptor{MediaType: MediaTypeOCIManifest, Digest: child.Digest, Size: int64(len(child.Body)), Platform: &platform})
+	}
+
+	body, err := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: descs})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	m := RawManifest{MediaType: MediaTypeOCIIndex, Digest: Digest(body), Body: body}
+	f.manifests[repository+":"+tag] = m
+	f.manifests[repository+":"+m.Digest] = m
+	return m
+}
+
+func TestClientBearerChallenge(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     func(host string) []Option
+		wantAuth string
+	}{
+		{
+			name:     "anonymous",
+			opts:     func(host string) []Option { return nil },
+			wantAuth: "",
+		},
+		{
+			name: "username and password",
+			opts: func(host string) []Option {
+				return []Option{WithCredential(host, "admin", "secret")}
+			},
+			// admin:secret
+			wantAuth: "Basic YWRtaW46c2VjcmV0",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeRegistry(t)
+			m := f.addImage("library/nginx", "1.25", Platform{OS: "linux", Architecture: "amd64"})
+			c := NewClient(tc.opts(f.host())...)
+
+			// 首次请求返回 401，客户端按照 challenge 获取 token 后重试
+			got, err := c.GetManifest(context.Background(), f.host(), "library/nginx", "1.25")
+			if err != nil {
+				t.Fatalf("GetManifest() error = %v", err)
+			}
+			if got.Digest != m.Digest {
+				t.Errorf("GetManifest() digest = %s, want %s", got.Digest, m.Digest)
+			}
+			if len(f.tokenScopes) != 1 || f.tokenScopes[0] != "repository:library/nginx:pull" {
+				t.Errorf("token scopes = %v", f.tokenScopes)
+			}
+			if f.tokenAuth[0] != tc.wantAuth {
+				t.Errorf("token request authorization = %q, want %q", f.tokenAuth[0], tc.wantAuth)
+			}
+
+			// 相同 scope 的 token 被缓存
+			if _, err = c.ManifestDigest(context.Background(), f.host(), "library/nginx", "1.25"); err != nil {
+				t.Fatalf("ManifestDigest() error = %v", err)
+			}
+			if len(f.tokenScopes) != 1 {
+				t.Errorf("token fetched %d times, want 1", len(f.tokenScopes))
+			}
+		})
+	}
+}
+
+func TestClientRegistryToken(t *testing.T) {
+	f := newFakeRegistry(t)
+	f.addImage("library/nginx", "1.25", Platform{OS: "linux", Architecture: "amd64"})
+
+	// 配置的 registry token 直接作为 Bearer token 使用，不请求 token 服务
+	c := NewClient(WithAuth(f.host(), Credential{RegistryToken: fakeToken}))
+	if _, err := c.GetManifest(context.Background(), f.host(), "library/nginx", "1.25"); err != nil {
+		t.Fatalf("GetManifest() error = %v", err)
+	}
+	if len(f.tokenScopes) != 0 {
+		t.Errorf("token service requested with a registry token: %v", f.tokenScopes)
+	}
+
+	c = NewClient(WithAuth(f.host(), Credential{RegistryToken: "invalid"}))
+	if _, err := c.GetManifest(context.Background(), f.host(), "library/nginx", "1.25"); err == nil {
+		t.Errorf("GetManifest() with an invalid token should fail")
+	}
+}
@@ -0,0 +1,282 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// CopyOptions 复制镜像的选项
+type CopyOptions struct {
+	// 只复制指定平台的镜像，为空时完整复制 manifest list / index
+	Platforms []Platform
+	// 按字节上报复制进度，已存在或者挂载的 blob 直接计为完成
+	Progress ProgressFunc
+}
+
+// Copy 将 src 镜像复制到 dst，镜像层在两个 registry 之间直接流式传输，不依赖 docker 也不占用本地磁盘。
+// 返回推送到 dst 的 manifest digest，未过滤平台时和源镜像的 digest 一致
+func (c *Client) Copy(ctx context.Context, src Reference, dst Reference, opts CopyOptions) (string, error) {
+	manifest, err := c.GetManifest(ctx, src.Registry, src.Repository, src.Identifier())
+	if err != nil {
+		return "", err
+	}
+
+	var progress *progressTracker
+	if opts.Progress != nil {
+		progress = newProgressTracker(opts.Progress)
+	}
+	if IsIndex(manifest.MediaType) {
+		if manifest, err = c.copyIndex(ctx, src, dst, manifest, opts.Platforms, progress); err != nil {
+			return "", err
+		}
+	} else {
+		if len(opts.Platforms) != 0 {
+			if err = c.checkImagePlatform(ctx, src, manifest, opts.Platforms); err != nil {
+				return "", err
+			}
+		}
+		if err = c.copyImage(ctx, src, dst, manifest, progress); err != nil {
+			return "", err
+		}
+	}
+
+	// 按 digest 引用的镜像在过滤平台后 digest 会变化，使用实际推送内容的 digest
+	reference := dst.Tag
+	if len(reference) == 0 {
+		reference = manifest.Digest
+	}
+	if err = c.PutManifest(ctx, dst.Registry, dst.Repository, reference, manifest); err != nil {
+		return "", err
+	}
+	return manifest.Digest, nil
+}
+
+// TargetDigest 计算 Copy 推送到目标仓库的 manifest digest，未过滤平台时为源镜像的 digest，
+// 用于判断目标仓库中的镜像是否已经是最新的
+func (c *Client) TargetDigest(ctx context.Context, src Reference, platforms []Platform) (string, error) {
+	manifest, err := c.GetManifest(ctx, src.Registry, src.Repository, src.Identifier())
+	if err != nil {
+		return "", err
+	}
+	if len(platforms) == 0 || !IsIndex(manifest.MediaType) {
+		return manifest.Digest, nil
+	}
+
+	_, index, err := filterIndex(src, manifest, platforms)
+	if err != nil {
+		return "", err
+	}
+	return index.Digest, nil
+}
+
+// PlatformDigest 返回 src 中指定平台镜像的 manifest digest，src 为单平台镜像时直接返回其 digest。
+// docker pull 只拉取单个平台的镜像，推送到目标仓库的是该平台镜像的 manifest
+func (c *Client) PlatformDigest(ctx context.Context, src Reference, platform Platform) (string, error) {
+	manifest, err := c.GetManifest(ctx, src.Registry, src.Repository, src.Identifier())
+	if err != nil {
+		return "", err
+	}
+	if !IsIndex(manifest.MediaType) {
+		return manifest.Digest, nil
+	}
+
+	children, _, err := filterIndex(src, manifest, []Platform{platform})
+	if err != nil {
+		return "", err
+	}
+	return children[0].Digest, nil
+}
+
+// copyIndex 复制 index 中匹配平台的镜像，返回需要推送的 index
+func (c *Client) copyIndex(ctx context.Context, src Reference, dst Reference, manifest *RawManifest, platforms []Platform, progress *progressTracker) (*RawManifest, error) {
+	children, index, err := filterIndex(src, manifest, platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先推送每个平台的镜像，index 引用的 manifest 必须已经存在
+	for _, desc := range children {
+		child, err := c.GetManifest(ctx, src.Registry, src.Repository, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err = c.copyImage(ctx, src, dst, child, progress); err != nil {
+			return nil, err
+		}
+		if err = c.PutManifest(ctx, dst.Registry, dst.Repository, child.Digest, child); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// filterIndex 返回 index 中匹配平台的镜像以及过滤后的 index，过滤后 index 的 digest 会发生变化
+func filterIndex(src Reference, manifest *RawManifest, platforms []Platform) ([]Descriptor, *RawManifest, error) {
+	var index map[string]json.RawMessage
+	if err := json.Unmarshal(manifest.Body, &index); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse index %s: %v", manifest.Digest, err)
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(index["manifests"], &entries); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse index %s: %v", manifest.Digest, err)
+	}
+
+	// 保留原始的条目，避免丢失 annotations 等字段
+	var (
+		selected []json.RawMessage
+		children []Descriptor
+	)
+	for _, entry := range entries {
+		var desc Descriptor
+		if err := json.Unmarshal(entry, &desc); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse index %s: %v", manifest.Digest, err)
+		}
+		if len(platforms) != 0 && (desc.Platform == nil || !MatchAny(platforms, *desc.Platform)) {
+			continue
+		}
+		selected = append(selected, entry)
+		children = append(children, desc)
+	}
+
+	if len(platforms) == 0 {
+		return children, manifest, nil
+	}
+	if len(selected) == 0 {
+		return nil, nil, fmt.Errorf("image %s does not provide any of platforms %s", src, platformsString(platforms))
+	}
+	if len(selected) == len(entries) {
+		return children, manifest, nil
+	}
+
+	raw, err := json.Marshal(selected)
+	if err != nil {
+		return nil, nil, err
+	}
+	index["manifests"] = raw
+	body, err := json.Marshal(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	return children, &RawManifest{MediaType: manifest.MediaType, Digest: Digest(body), Body: body}, nil
+}
+
+// checkImagePlatform 单平台镜像通过 config 中的平台信息判断是否满足要求
+func (c *Client) checkImagePlatform(ctx context.Context, src Reference, manifest *RawManifest, platforms []Platform) error {
+	image, err := manifest.Parse()
+	if err != nil {
+		return err
+	}
+	body, _, err := c.GetBlob(ctx, src.Registry, src.Repository, image.Config.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(&limitedReader{r: body, n: maxManifestSize})
+	if err != nil {
+		return err
+	}
+	var platform Platform
+	if err = json.Unmarshal(data, &platform); err != nil {
+		return fmt.Errorf("failed to parse image config %s: %v", image.Config.Digest, err)
+	}
+	if !MatchAny(platforms, platform) {
+		return fmt.Errorf("image %s is %s, does not match platforms %s", src, platform, platformsString(platforms))
+	}
+	return nil
+}
+
+func platformsString(platforms []Platform) string {
+	var s []string
+	for _, p := range platforms {
+		s = append(s, p.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// copyImage 复制镜像 manifest 引用的 config 和镜像层
+func (c *Client) copyImage(ctx context.Context, src Reference, dst Reference, manifest *RawManifest, progress *progressTracker) error {
+	image, err := manifest.Parse()
+	if err != nil {
+		return err
+	}
+	if IsIndex(image.MediaType) || len(image.Manifests) != 0 {
+		return fmt.Errorf("nested index %s is not supported", manifest.Digest)
+	}
+
+	blobs := append([]Descriptor{image.Config}, image.Layers...)
+	var total int64
+	for _, blob := range blobs {
+		total += blob.Size
+	}
+	progress.addTotal(total)
+
+	for _, blob := range blobs {
+		if err = c.copyBlob(ctx, src, dst, blob, progress); err != nil {
+			return fmt.Errorf("failed to copy blob %s: %w", blob.Digest, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) copyBlob(ctx context.Context, src Reference, dst Reference, blob Descriptor, progress *progressTracker) error {
+	// 外部镜像层（例如 windows 基础镜像）不存储在 registry 中
+	if strings.Contains(blob.MediaType, "foreign") || len(blob.URLs) != 0 {
+		progress.addCurrent(blob.Size)
+		return nil
+	}
+
+	exists, err := c.BlobExists(ctx, dst.Registry, dst.Repository, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		klog.V(2).Infof("blob %s already exists in %s", blob.Digest, dst.Repository)
+		progress.addCurrent(blob.Size)
+		return nil
+	}
+
+	// 同一 registry 内优先跨仓库挂载，避免重复传输
+	if src.Registry == dst.Registry && src.Repository != dst.Repository {
+		scopes := mountScopes(dst.Repository, src.Repository)
+		mounted, location, err := c.MountBlob(ctx, dst.Registry, dst.Repository, src.Repository, blob.Digest)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			klog.V(2).Infof("blob %s mounted from %s to %s", blob.Digest, src.Repository, dst.Repository)
+			progress.addCurrent(blob.Size)
+			return nil
+		}
+		return c.streamBlob(ctx, src, dst, blob, progress, func(r *countingReader) error {
+			return c.completeUpload(ctx, dst.Registry, scopes, location, blob.Digest, blob.Size, r)
+		})
+	}
+
+	return c.streamBlob(ctx, src, dst, blob, progress, func(r *countingReader) error {
+		return c.UploadBlob(ctx, dst.Registry, dst.Repository, blob.Digest, blob.Size, r)
+	})
+}
+
+// streamBlob 从源仓库读取 blob 并交给 upload 上传到目标仓库
+func (c *Client) streamBlob(ctx context.Context, src Reference, dst Reference, blob Descriptor, progress *progressTracker, upload func(r *countingReader) error) error {
+	body, _, err := c.GetBlob(ctx, src.Registry, src.Repository, blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	r := &countingReader{r: body, progress: progress}
+	if err = upload(r); err != nil {
+		return err
+	}
+	if r.n != blob.Size {
+		return fmt.Errorf("blob size mismatch, expected %d got %d", blob.Size, r.n)
+	}
+	return nil
+}
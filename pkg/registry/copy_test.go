@@ -0,0 +1,287 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func mustParseReference(t *testing.T, image string) Reference {
+	ref, err := ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestCopy(t *testing.T) {
+	tests := []struct {
+		name      string
+		index     bool
+		platforms []Platform
+		// 目标仓库中应当存在的平台镜像数量
+		wantChildren int
+		wantUploads  int
+		// 推送的 digest 和源镜像一致
+		wantSameDigest bool
+	}{
+		{name: "image", wantUploads: 2, wantSameDigest: true},
+		{name: "image matching platform", platforms: []Platform{{OS: "linux", Architecture: "amd64"}}, wantUploads: 2, wantSameDigest: true},
+		{name: "index", index: true, wantChildren: 2, wantUploads: 4, wantSameDigest: true},
+		{name: "index with all platforms", index: true, platforms: []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}, wantChildren: 2, wantUploads: 4, wantSameDigest: true},
+		{name: "index filtered by platform", index: true, platforms: []Platform{{OS: "linux", Architecture: "arm64"}}, wantChildren: 1, wantUploads: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src, dst := newFakeRegistry(t), newFakeRegistry(t)
+			var source RawManifest
+			if tc.index {
+				source = src.addIndex("library/nginx", "1.25")
+			} else {
+				source = src.addImage("library/nginx", "1.25", Platform{OS: "linux", Architecture: "amd64"})
+			}
+
+			var last Progress
+			c := NewClient()
+			digest, err := c.Copy(context.Background(),
+				mustParseReference(t, src.host()+"/library/nginx:1.25"),
+				mustParseReference(t, dst.host()+"/mirror/nginx:1.25"),
+				CopyOptions{Platforms: tc.platforms, Progress: func(p Progress) { last = p }})
+			if err != nil {
+				t.Fatalf("Copy() error = %v", err)
+			}
+			if (digest == source.Digest) != tc.wantSameDigest {
+				t.Errorf("Copy() digest = %s, source digest %s", digest, source.Digest)
+			}
+
+			pushed, ok := dst.manifests["mirror/nginx:1.25"]
+			if !ok {
+				t.Fatalf("manifest not pushed to target")
+			}
+			if pushed.Digest != digest {
+				t.Errorf("pushed digest = %s, want %s", pushed.Digest, digest)
+			}
+			parsed, err := pushed.Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parsed.Manifests) != tc.wantChildren {
+				t.Errorf("pushed index has %d manifests, want %d", len(parsed.Manifests), tc.wantChildren)
+			}
+			// index 引用的平台镜像必须先推送
+			for _, child := range parsed.Manifests {
+				if _, ok = dst.manifests["mirror/nginx:"+child.Digest]; !ok {
+					t.Errorf("child manifest %s not pushed", child.Digest)
+				}
+			}
+			if dst.uploads != tc.wantUploads {
+				t.Errorf("uploads = %d, want %d", dst.uploads, tc.wantUploads)
+			}
+			if last.Current != last.Total || last.Total == 0 {
+				t.Errorf("progress = %d/%d", last.Current, last.Total)
+			}
+
+			// 再次复制时 blob 已存在，不重复上传
+			if _, err = c.Copy(context.Background(),
+				mustParseReference(t, src.host()+"/library/nginx:1.25"),
+				mustParseReference(t, dst.host()+"/mirror/nginx:1.25"),
+				CopyOptions{Platforms: tc.platforms}); err != nil {
+				t.Fatalf("second Copy() error = %v", err)
+			}
+			if dst.uploads != tc.wantUploads {
+				t.Errorf("uploads after second copy = %d, want %d", dst.uploads, tc.wantUploads)
+			}
+		})
+	}
+}
+
+func TestCopyPlatformMismatch(t *testing.T) {
+	src, dst := newFakeRegistry(t), newFakeRegistry(t)
+	src.addImage("library/nginx", "amd64", Platform{OS: "linux", Architecture: "amd64"})
+	src.addIndex("library/nginx", "multi")
+
+	platforms := []Platform{{OS: "linux", Architecture: "s390x"}}
+	for _, tag := range []string{"amd64", "multi"} {
+		_, err := NewClient().Copy(context.Background(),
+			mustParseReference(t, src.host()+"/library/nginx:"+tag),
+			mustParseReference(t, dst.host()+"/mirror/nginx:"+tag),
+			CopyOptions{Platforms: platforms})
+		if err == nil || !strings.Contains(err.Error(), "linux/s390x") {
+			t.Errorf("Copy() of %s error = %v, want platform mismatch", tag, err)
+		}
+		if _, ok := dst.manifests["mirror/nginx:"+tag]; ok {
+			t.Errorf("manifest %s pushed although no platform matches", tag)
+		}
+	}
+}
+
+func TestCopyCrossRepositoryMount(t *testing.T) {
+	tests := []struct {
+		name        string
+		noMount     bool
+		wantMounts  int
+		wantUploads int
+	}{
+		{name: "mount", wantMounts: 2},
+		// registry 不支持挂载时返回上传地址，直接在该地址上传
+		{name: "mount not supported", noMount: true, wantUploads: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeRegistry(t)
+			f.noMount = tc.noMount
+			source := f.addImage("library/nginx", "1.25", Platform{OS: "linux", Architecture: "amd64"})
+
+			digest, err := NewClient().Copy(context.Background(),
+				mustParseReference(t, f.host()+"/library/nginx:1.25"),
+				mustParseReference(t, f.host()+"/mirror/nginx:1.25"),
+				CopyOptions{})
+			if err != nil {
+				t.Fatalf("Copy() error = %v", err)
+			}
+			if digest != source.Digest {
+				t.Errorf("Copy() digest = %s, want %s", digest, source.Digest)
+			}
+			if f.mounts != tc.wantMounts || f.uploads != tc.wantUploads {
+				t.Errorf("mounts = %d, uploads = %d, want %d and %d", f.mounts, f.uploads, tc.wantMounts, tc.wantUploads)
+			}
+
+			image, _ := source.Parse()
+			for _, blob := range append([]Descriptor{image.Config}, image.Layers...) {
+				if _, ok := f.blobs["mirror/nginx@"+blob.Digest]; !ok {
+					t.Errorf("blob %s missing in target repository", blob.Digest)
+				}
+			}
+			// 跨仓库挂载需要同时申请两个仓库的权限
+			if !containsString(f.tokenScopes, "repository:mirror/nginx:pull,push repository:library/nginx:pull") {
+				t.Errorf("token scopes = %v", f.tokenScopes)
+			}
+		})
+	}
+}
+
+func TestCopyDigestMismatch(t *testing.T) {
+	t.Run("manifest", func(t *testing.T) {
+		src, dst := newFakeRegistry(t), newFakeRegistry(t)
+		source := src.addImage("library/nginx", "1.25", Platform{OS: "linux", Architecture: "amd64"})
+		other := src.addImage("library/nginx", "1.26", Platform{OS: "linux", Architecture: "amd64"})
+		// registry 按 digest 返回了其他内容
+		src.manifests["library/nginx:"+source.Digest] = other
+
+		_, err := NewClient().Copy(context.Background(),
+			mustParseReference(t, src.host()+"/library/nginx@"+source.Digest),
+			mustParseReference(t, dst.host()+"/mirror/nginx:1.25"),
+			CopyOptions{})
+		if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+			t.Fatalf("Copy() error = %v, want digest mismatch", err)
+		}
+		if len(dst.manifests) != 0 {
+			t.Errorf("manifests pushed after digest mismatch: %d", len(dst.manifests))
+		}
+	})
+
+	t.Run("blob", func(t *testing.T) {
+		src, dst := newFakeRegistry(t), newFakeRegistry(t)
+		source := src.addImage("library/nginx", "1.25", Platform{OS: "linux", Architecture: "amd64"})
+		image, _ := source.Parse()
+		layer := image.Layers[0]
+		// 镜像层内容被篡改，长度不变
+		corrupted := []byte(strings.Repeat("x", int(layer.Size)))
+		src.blobs["library/nginx@"+layer.Digest] = corrupted
+
+		_, err := NewClient().Copy(context.Background(),
+			mustParseReference(t, src.host()+"/library/nginx:1.25"),
+			mustParseReference(t, dst.host()+"/mirror/nginx:1.25"),
+			CopyOptions{})
+		if err == nil || !strings.Contains(err.Error(), layer.Digest) {
+			t.Fatalf("Copy() error = %v, want upload of %s rejected", err, layer.Digest)
+		}
+		if _, ok := dst.blobs["mirror/nginx@"+layer.Digest]; ok {
+			t.Errorf("corrupted blob accepted by target registry")
+		}
+		if _, ok := dst.manifests["mirror/nginx:1.25"]; ok {
+			t.Errorf("manifest pushed after blob digest mismatch")
+		}
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTargetDigest(t *testing.T) {
+	src, dst := newFakeRegistry(t), newFakeRegistry(t)
+	index := src.addIndex("library/nginx", "1.25")
+	image := src.addImage("library/nginx", "amd64", Platform{OS: "linux", Architecture: "amd64"})
+
+	tests := []struct {
+		tag       string
+		platforms []Platform
+		want      string
+	}{
+		{tag: "1.25", want: index.Digest},
+		{tag: "1.25", platforms: []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}, want: index.Digest},
+		// 过滤平台后的 digest 和 Copy 推送的 index 一致
+		{tag: "1.25", platforms: []Platform{{OS: "linux", Architecture: "arm64"}}},
+		{tag: "amd64", platforms: []Platform{{OS: "linux", Architecture: "amd64"}}, want: image.Digest},
+	}
+
+	c := NewClient()
+	for _, tc := range tests {
+		ref := mustParseReference(t, src.host()+"/library/nginx:"+tc.tag)
+		got, err := c.TargetDigest(context.Background(), ref, tc.platforms)
+		if err != nil {
+			t.Fatalf("TargetDigest() error = %v", err)
+		}
+		want := tc.want
+		if len(want) == 0 {
+			if want, err = c.Copy(context.Background(), ref, mustParseReference(t, dst.host()+"/mirror/nginx:"+tc.tag), CopyOptions{Platforms: tc.platforms}); err != nil {
+				t.Fatal(err)
+			}
+			if want == index.Digest {
+				t.Fatalf("filtered index has the source digest")
+			}
+		}
+		if got != want {
+			t.Errorf("TargetDigest(%s, %v) = %s, want %s", tc.tag, tc.platforms, got, want)
+		}
+	}
+}
+
+func TestPlatformDigest(t *testing.T) {
+	src := newFakeRegistry(t)
+	index := src.addIndex("library/nginx", "1.25")
+	image := src.addImage("library/nginx", "amd64", Platform{OS: "linux", Architecture: "amd64"})
+	parsed, _ := index.Parse()
+
+	tests := []struct {
+		tag      string
+		platform Platform
+		want     string
+		wantErr  bool
+	}{
+		{tag: "1.25", platform: Platform{OS: "linux", Architecture: "amd64"}, want: parsed.Manifests[0].Digest},
+		{tag: "1.25", platform: Platform{OS: "linux", Architecture: "arm64"}, want: parsed.Manifests[1].Digest},
+		{tag: "1.25", platform: Platform{OS: "linux", Architecture: "s390x"}, wantErr: true},
+		// 单平台镜像直接返回镜像的 digest
+		{tag: "amd64", platform: Platform{OS: "linux", Architecture: "arm64"}, want: image.Digest},
+	}
+
+	c := NewClient()
+	for _, tc := range tests {
+		got, err := c.PlatformDigest(context.Background(), mustParseReference(t, src.host()+"/library/nginx:"+tc.tag), tc.platform)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("PlatformDigest(%s, %s) error = %v, want error %v", tc.tag, tc.platform, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("PlatformDigest(%s, %s) = %s, want %s", tc.tag, tc.platform, got, tc.want)
+		}
+	}
+}
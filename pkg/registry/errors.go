@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// 无法通过类型判断的错误，例如 docker 客户端返回的错误，按照错误信息判断
+var transientMessages = []string{
+	"toomanyrequests",
+	"too many requests",
+	"connection reset by peer",
+	"tls handshake timeout",
+	"i/o timeout",
+	"unexpected eof",
+	"503 service unavailable",
+	"502 bad gateway",
+	"504 gateway timeout",
+}
+
+// 表示镜像或者仓库不存在的错误码
+var notFoundCodes = []string{"MANIFEST_UNKNOWN", "NAME_UNKNOWN"}
+
+// isManifestUnknown 判断 registry 返回的错误是否表示镜像不存在，不只依赖 404 状态码
+func isManifestUnknown(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	if statusErr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	for _, code := range statusErr.Codes {
+		for _, c := range notFoundCodes {
+			if code == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsTransient 判断错误是否为可以重试的临时错误，例如限流，服务端错误和连接被重置
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ErrNotFound 镜像在 registry 中不存在
+var ErrNotFound = errors.New("manifest not found")
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+
+	// manifest 的大小上限，和 distribution 保持一致
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+var manifestAccept = strings.Join([]string{
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifest,
+}, ", ")
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest 同时兼容镜像 manifest 和 manifest list / index
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// RawManifest 保留 registry 返回的原始内容，推送时保证 digest 不变
+type RawManifest struct {
+	MediaType string
+	Digest    string
+	Body      []byte
+}
+
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList
+}
+
+func (m *RawManifest) Parse() (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(m.Body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %v", m.Digest, err)
+	}
+	if manifest.SchemaVersion != 2 {
+		return nil, fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+	}
+	return &manifest, nil
+}
+
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// GetManifest 获取镜像的 manifest，reference 为 tag 或 digest
+func (c *Client) GetManifest(ctx context.Context, host string, repository string, reference string) (*RawManifest, error) {
+	resp, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		host:   host,
+		path:   fmt.Sprintf("/v2/%s/manifests/%s", repository, reference),
+		scopes: []string{repositoryScope(repository, "pull")},
+		header: http.Header{"Accept": []string{manifestAccept}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get manifest %s/%s:%s: %w", host, repository, reference, responseError(resp))
+	}
+
+	body, err := ioutil.ReadAll(&limitedReader{r: resp.Body, n: maxManifestSize})
+	if err != nil {
+		return nil, err
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	if !IsIndex(mediaType) && mediaType != MediaTypeOCIManifest && mediaType != MediaTypeDockerManifest {
+		// 部分 registry 不返回准确的 Content-Type，以 manifest 内容为准
+		var m Manifest
+		if err = json.Unmarshal(body, &m); err == nil && len(m.MediaType) != 0 {
+			mediaType = m.MediaType
+		}
+	}
+
+	digest := Digest(body)
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return nil, fmt.Errorf("manifest digest mismatch, expected %s got %s", reference, digest)
+	}
+	return &RawManifest{MediaType: mediaType, Digest: digest, Body: body}, nil
+}
+
+// ManifestDigest 获取 manifest 的 digest，优先使用 HEAD 请求避免下载 manifest，镜像不存在时返回 ErrNotFound
+func (c *Client) ManifestDigest(ctx context.Context, host string, repository string, reference string) (string, error) {
+	resp, err := c.do(ctx, &request{
+		method: http.MethodHead,
+		host:   host,
+		path:   fmt.Sprintf("/v2/%s/manifests/%s", repository, reference),
+		scopes: []string{repositoryScope(repository, "pull")},
+		header: http.Header{"Accept": []string{manifestAccept}},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer drainBody(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if digest := resp.Header.Get("Docker-Content-Digest"); len(digest) != 0 {
+			return digest, nil
+		}
+	case http.StatusNotFound:
+		return "", ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// HEAD 的响应没有 body，通过 GET 获取错误码，部分 registry 对不存在的仓库返回 401/403 和 NAME_UNKNOWN
+		manifest, err := c.GetManifest(ctx, host, repository, reference)
+		if err != nil {
+			if isManifestUnknown(err) {
+				return "", ErrNotFound
+			}
+			return "", err
+		}
+		return manifest.Digest, nil
+	default:
+		return "", fmt.Errorf("failed to head manifest %s/%s:%s: %w", host, repository, reference, &StatusError{StatusCode: resp.StatusCode})
+	}
+
+	// registry 未返回 digest 时下载 manifest 自行计算
+	manifest, err := c.GetManifest(ctx, host, repository, reference)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Digest, nil
+}
+
+// PutManifest 推送 manifest，reference 为 tag 或 digest
+func (c *Client) PutManifest(ctx context.Context, host string, repository string, reference string, manifest *RawManifest) error {
+	resp, err := c.do(ctx, &request{
+		method:  http.MethodPut,
+		host:    host,
+		path:    fmt.Sprintf("/v2/%s/manifests/%s", repository, reference),
+		scopes:  []string{repositoryScope(repository, "pull,push")},
+		header:  http.Header{"Content-Type": []string{manifest.MediaType}},
+		content: manifest.Body,
+	})
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to put manifest %s/%s:%s: %w", host, repository, reference, responseError(resp))
+	}
+	return nil
+}
+
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, fmt.Errorf("manifest exceeds %d bytes", maxManifestSize)
+	}
+	return n, err
+}
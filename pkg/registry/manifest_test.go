@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestManifestDigestNotFound(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		code         string
+		wantNotFound bool
+	}{
+		{name: "404", status: http.StatusNotFound, code: "MANIFEST_UNKNOWN", wantNotFound: true},
+		{name: "404 without body", status: http.StatusNotFound, wantNotFound: true},
+		// 例如 Harbor 和 ECR 对不存在的仓库返回 401/403
+		{name: "401 name unknown", status: http.StatusUnauthorized, code: "NAME_UNKNOWN", wantNotFound: true},
+		{name: "403 manifest unknown", status: http.StatusForbidden, code: "MANIFEST_UNKNOWN", wantNotFound: true},
+		{name: "401 unauthorized", status: http.StatusUnauthorized, code: "UNAUTHORIZED"},
+		{name: "403 denied", status: http.StatusForbidden, code: "DENIED"},
+		{name: "500", status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// 认证后仍然返回 401
+				if tc.status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+				}
+				if tc.code == "" || r.Method == http.MethodHead {
+					w.WriteHeader(tc.status)
+					return
+				}
+				writeRegistryError(w, tc.status, tc.code)
+			}))
+			defer srv.Close()
+
+			c := NewClient(WithCredential(strings.TrimPrefix(srv.URL, "http://"), "admin", "secret"))
+			_, err := c.ManifestDigest(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "library/nginx", "1.25")
+			if (err == ErrNotFound) != tc.wantNotFound {
+				t.Errorf("ManifestDigest() error = %v, want not found %v", err, tc.wantNotFound)
+			}
+			if err == nil {
+				t.Errorf("ManifestDigest() should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// FlattenNaming 只保留源镜像仓库路径的最后一段，例如 docker.io/library/nginx -> <namespace>/nginx
+	FlattenNaming = "flatten"
+	// PreservePathNaming 保留源镜像的完整仓库路径，例如 docker.io/library/nginx -> <namespace>/library/nginx
+	PreservePathNaming = "preserve-path"
+	// PrefixRegistryNaming 在完整仓库路径前加上源 registry，例如 quay.io/foo/nginx -> <namespace>/quay.io/foo/nginx
+	PrefixRegistryNaming = "prefix-registry"
+	// RewriteNaming 按照正则规则改写，没有匹配的规则时按 flatten 处理
+	RewriteNaming = "rewrite"
+)
+
+// NamingRule 正则改写规则，Match 匹配 <registry>/<repository>，Replace 支持 $1 等引用，结果为 namespace 下的仓库路径
+type NamingRule struct {
+	Match   string `json:"match" yaml:"match"`
+	Replace string `json:"replace" yaml:"replace"`
+}
+
+// NamingPolicy 源镜像到目标镜像的命名规则，Mode 为空时为 flatten
+type NamingPolicy struct {
+	Mode  string       `json:"mode,omitempty" yaml:"mode"`
+	Rules []NamingRule `json:"rules,omitempty" yaml:"rules"`
+}
+
+func (n NamingPolicy) IsEmpty() bool {
+	return len(n.Mode) == 0 && len(n.Rules) == 0
+}
+
+func (n NamingPolicy) Validate() error {
+	switch n.Mode {
+	case "", FlattenNaming, PreservePathNaming, PrefixRegistryNaming:
+		if len(n.Rules) != 0 {
+			return fmt.Errorf("naming rules are only supported in %s mode", RewriteNaming)
+		}
+	case RewriteNaming:
+		if len(n.Rules) == 0 {
+			return fmt.Errorf("%s mode requires at least one rule", RewriteNaming)
+		}
+		for _, rule := range n.Rules {
+			if _, err := regexp.Compile(rule.Match); err != nil {
+				return fmt.Errorf("invalid naming rule %q: %v", rule.Match, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported naming mode %s", n.Mode)
+	}
+	return nil
+}
+
+// EncodeNamingPolicy 命名规则以 json 格式存储，空规则存储为空字符串
+func EncodeNamingPolicy(n NamingPolicy) (string, error) {
+	if n.IsEmpty() {
+		return "", nil
+	}
+	if err := n.Validate(); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(n)
+	return string(data), err
+}
+
+func DecodeNamingPolicy(s string) (NamingPolicy, error) {
+	var n NamingPolicy
+	if len(s) == 0 {
+		return n, nil
+	}
+	if err := json.Unmarshal([]byte(s), &n); err != nil {
+		return n, fmt.Errorf("invalid naming policy %s: %v", s, err)
+	}
+	return n, nil
+}
+
+// TargetImage 按照命名规则计算源镜像在目标仓库中的地址，保留源镜像的 tag 和 digest
+func TargetImage(n NamingPolicy, repository string, namespace string, source string) (string, error) {
+	src, err := ParseReference(source)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := n.targetPath(src)
+	if err != nil {
+		return "", err
+	}
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return "", fmt.Errorf("empty target repository for image %s", source)
+	}
+
+	target := path
+	if len(namespace) != 0 {
+		target = namespace + "/" + target
+	}
+	if len(repository) != 0 {
+		target = repository + "/" + target
+	}
+	if len(src.Tag) != 0 {
+		target += ":" + src.Tag
+	}
+	if len(src.Digest) != 0 {
+		target += "@" + src.Digest
+	}
+	return target, nil
+}
+
+func (n NamingPolicy) targetPath(src Reference) (string, error) {
+	parts := strings.Split(src.Repository, "/")
+	flatten := parts[len(parts)-1]
+
+	switch n.Mode {
+	case "", FlattenNaming:
+		return flatten, nil
+	case PreservePathNaming:
+		return src.Repository, nil
+	case PrefixRegistryNaming:
+		// 仓库路径中不允许出现端口分隔符
+		return strings.Replace(src.Registry, ":", "-", -1) + "/" + src.Repository, nil
+	case RewriteNaming:
+		name := src.Registry + "/" + src.Repository
+		for _, rule := range n.Rules {
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return "", fmt.Errorf("invalid naming rule %q: %v", rule.Match, err)
+			}
+			if re.MatchString(name) {
+				return re.ReplaceAllString(name, rule.Replace), nil
+			}
+		}
+		return flatten, nil
+	}
+	return "", fmt.Errorf("unsupported naming mode %s", n.Mode)
+}
+
+// FindCollisions 检查不同的源镜像是否会同步到同一个目标镜像，返回冲突的描述
+func FindCollisions(n NamingPolicy, repository string, namespace string, sources []string) ([]string, error) {
+	targets := make(map[string][]string)
+	for _, source := range sources {
+		target, err := TargetImage(n, repository, namespace, source)
+		if err != nil {
+			return nil, err
+		}
+		// 同一个镜像的不同写法不算冲突，例如 nginx 和 docker.io/library/nginx:latest
+		src, _ := ParseReference(source)
+		normalized := src.String()
+		exists := false
+		for _, s := range targets[target] {
+			if s == normalized {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			targets[target] = append(targets[target], normalized)
+		}
+	}
+
+	var collisions []string
+	for target, srcs := range targets {
+		if len(srcs) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s <- %s", target, strings.Join(srcs, ", ")))
+		}
+	}
+	sort.Strings(collisions)
+	return collisions, nil
+}
@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParsePlatform 解析 os/arch[/variant] 格式的平台，例如 linux/arm64/v8
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+	for _, part := range parts {
+		if len(part) == 0 {
+			return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+		}
+	}
+
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// ParsePlatforms 解析逗号分隔的平台列表，为空时返回 nil 表示不过滤
+func ParsePlatforms(s string) ([]Platform, error) {
+	var platforms []Platform
+	for _, item := range strings.Split(s, ",") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		p, err := ParsePlatform(item)
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+func (p Platform) String() string {
+	if len(p.Variant) != 0 {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// Match 判断 other 是否满足 p，p 未指定 variant 时匹配所有 variant
+func (p Platform) Match(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return len(p.Variant) == 0 || p.Variant == other.Variant
+}
+
+// MatchAny 平台列表为空时匹配所有平台
+func MatchAny(platforms []Platform, other Platform) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	for _, p := range platforms {
+		if p.Match(other) {
+			return true
+		}
+	}
+	return false
+}
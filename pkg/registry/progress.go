@@ -0,0 +1,42 @@
+package registry
+
+// Progress 复制镜像的进度，Total 随着 manifest 的解析逐步增加
+type Progress struct {
+	Current int64
+	Total   int64
+}
+
+// ProgressFunc 接收复制进度，调用频率较高，实现方需要自行限流
+type ProgressFunc func(Progress)
+
+// progressTracker 汇总一次复制中所有 blob 的进度，Copy 是串行执行的，不需要加锁
+type progressTracker struct {
+	progress Progress
+	fn       ProgressFunc
+}
+
+func newProgressTracker(fn ProgressFunc) *progressTracker {
+	return &progressTracker{fn: fn}
+}
+
+func (t *progressTracker) addTotal(n int64) {
+	if t == nil {
+		return
+	}
+	t.progress.Total += n
+	t.report()
+}
+
+func (t *progressTracker) addCurrent(n int64) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.progress.Current += n
+	t.report()
+}
+
+func (t *progressTracker) report() {
+	if t.fn != nil {
+		t.fn(t.progress)
+	}
+}
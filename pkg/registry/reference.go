@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultRegistry = "docker.io"
+	DefaultTag      = "latest"
+
+	// docker.io 实际的 API 地址
+	dockerHubEndpoint = "registry-1.docker.io"
+)
+
+// Reference 镜像地址，例如 docker.io/library/nginx:1.25
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference 解析镜像地址，未指定 registry 时为 docker.io，未指定 tag 和 digest 时为 latest
+func ParseReference(image string) (Reference, error) {
+	var ref Reference
+
+	name := strings.TrimSpace(image)
+	if len(name) == 0 {
+		return ref, fmt.Errorf("empty image reference")
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !strings.Contains(ref.Digest, ":") {
+			return ref, fmt.Errorf("invalid digest in image %s", image)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry, name = parts[0], parts[1]
+	} else {
+		ref.Registry = DefaultRegistry
+	}
+	if ref.Registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if len(name) == 0 || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		return ref, fmt.Errorf("invalid repository in image %s", image)
+	}
+	ref.Repository = name
+
+	if len(ref.Tag) == 0 && len(ref.Digest) == 0 {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// Identifier 获取 manifest 时使用的标识，优先使用 digest
+func (r Reference) Identifier() string {
+	if len(r.Digest) != 0 {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if len(r.Tag) != 0 {
+		s += ":" + r.Tag
+	}
+	if len(r.Digest) != 0 {
+		s += "@" + r.Digest
+	}
+	return s
+}
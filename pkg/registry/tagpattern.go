@@ -0,0 +1,294 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// GlobTagPattern 通配符，例如 nginx:1.25.*
+	GlobTagPattern = "glob"
+	// SemverTagPattern 版本范围，例如 redis:>=7.0 <8，支持 >=, >, <=, <, =, !=, ~ 和 ^
+	SemverTagPattern = "semver"
+	// RegexTagPattern 正则表达式，例如 alpine:/^3\.1[89]/
+	RegexTagPattern = "regex"
+
+	// DefaultMaxTags 每个匹配规则默认最多展开的 tag 数量
+	DefaultMaxTags = 10
+)
+
+var (
+	// 普通的 tag，不包含匹配规则
+	plainTagRegexp = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	// 用于版本比较的 tag，例如 1.25, v1.25.3，不包含 -alpine 等后缀
+	semverTagRegexp  = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?$`)
+	constraintRegexp = regexp.MustCompile(`^(>=|<=|!=|>|<|=|~|\^)?\s*(\S+)$`)
+)
+
+// TagPattern 镜像 tag 的匹配规则，Name 为不包含 tag 的镜像名称，例如 nginx
+type TagPattern struct {
+	Name    string
+	Kind    string
+	Pattern string
+
+	regex       *regexp.Regexp
+	constraints []constraint
+}
+
+// ParseTagPattern 解析带有 tag 匹配规则的镜像，普通的镜像返回 false
+func ParseTagPattern(image string) (*TagPattern, bool, error) {
+	image = strings.TrimSpace(image)
+	if strings.Contains(image, "@") {
+		return nil, false, nil
+	}
+
+	var name, pattern string
+	if i := strings.Index(image, ":/"); i > 0 && len(image) > i+3 && strings.HasSuffix(image, "/") {
+		name, pattern = image[:i], image[i+1:]
+	} else {
+		// 版本范围中可能包含空格，tag 的分隔符在第一个空格之前
+		head := image
+		if j := strings.Index(image, " "); j >= 0 {
+			head = image[:j]
+		}
+		i := strings.LastIndex(head, ":")
+		if i <= strings.LastIndex(head, "/") {
+			return nil, false, nil
+		}
+		name, pattern = image[:i], image[i+1:]
+	}
+	if plainTagRegexp.MatchString(pattern) {
+		return nil, false, nil
+	}
+
+	t := &TagPattern{Name: name, Pattern: pattern}
+	var err error
+	switch {
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		t.Kind = RegexTagPattern
+		if t.regex, err = regexp.Compile(pattern[1 : len(pattern)-1]); err != nil {
+			return nil, true, fmt.Errorf("invalid tag regex in %s: %v", image, err)
+		}
+	case strings.ContainsAny(pattern[:1], "<>=!~^"):
+		t.Kind = SemverTagPattern
+		if t.constraints, err = parseConstraints(pattern); err != nil {
+			return nil, true, fmt.Errorf("invalid version range in %s: %v", image, err)
+		}
+	case strings.ContainsAny(pattern, "*?["):
+		t.Kind = GlobTagPattern
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, true, fmt.Errorf("invalid tag pattern in %s: %v", image, err)
+		}
+	default:
+		return nil, true, fmt.Errorf("invalid tag pattern in %s", image)
+	}
+	return t, true, nil
+}
+
+func (t *TagPattern) Match(tag string) bool {
+	switch t.Kind {
+	case RegexTagPattern:
+		return t.regex.MatchString(tag)
+	case GlobTagPattern:
+		ok, _ := path.Match(t.Pattern, tag)
+		return ok
+	case SemverTagPattern:
+		v, ok := parseVersion(tag)
+		if !ok {
+			return false
+		}
+		for _, c := range t.constraints {
+			if !c.match(v) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Select 返回匹配的 tag，按版本从新到旧排序，无法比较版本的 tag 按字符串倒序排在后面，最多 max 个
+func (t *TagPattern) Select(tags []string, max int) []string {
+	var matched []string
+	for _, tag := range tags {
+		if t.Match(tag) {
+			matched = append(matched, tag)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		vi, oki := parseVersion(matched[i])
+		vj, okj := parseVersion(matched[j])
+		switch {
+		case oki && okj:
+			if c := vi.compare(vj); c != 0 {
+				return c > 0
+			}
+			return matched[i] > matched[j]
+		case oki != okj:
+			return oki
+		}
+		return matched[i] > matched[j]
+	})
+
+	if max > 0 && len(matched) > max {
+		matched = matched[:max]
+	}
+	return matched
+}
+
+// ExpandTagPattern 通过 tags API 列出源仓库的 tag，返回匹配的镜像，没有匹配的 tag 时返回错误
+func (c *Client) ExpandTagPattern(ctx context.Context, t *TagPattern, max int) ([]string, error) {
+	ref, err := ParseReference(t.Name)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.ListTags(ctx, ref.Registry, ref.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := t.Select(tags, max)
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no tags of %s match %s", t.Name, t.Pattern)
+	}
+	var images []string
+	for _, tag := range selected {
+		images = append(images, t.Name+":"+tag)
+	}
+	return images, nil
+}
+
+// ExpandTagPatterns 展开镜像列表中的 tag 匹配规则，普通的镜像保持不变，max 小于等于 0 时为 DefaultMaxTags
+func (c *Client) ExpandTagPatterns(ctx context.Context, images []string, max int) ([]string, error) {
+	if max <= 0 {
+		max = DefaultMaxTags
+	}
+
+	var expanded []string
+	for _, image := range images {
+		t, ok, err := ParseTagPattern(image)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			expanded = append(expanded, image)
+			continue
+		}
+		found, err := c.ExpandTagPattern(ctx, t, max)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, found...)
+	}
+	return expanded, nil
+}
+
+type version [3]int
+
+func parseVersion(s string) (version, bool) {
+	v, _, ok := parseVersionParts(s)
+	return v, ok
+}
+
+// parseVersionParts 同时返回指定的版本段数，用于计算 ~ 和 ^ 的范围
+func parseVersionParts(s string) (version, int, bool) {
+	var v version
+	match := semverTagRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return v, 0, false
+	}
+	parts := 0
+	for i := 0; i < 3; i++ {
+		if len(match[i+1]) == 0 {
+			break
+		}
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return v, 0, false
+		}
+		v[i] = n
+		parts++
+	}
+	return v, parts, true
+}
+
+func (v version) compare(other version) int {
+	for i := 0; i < 3; i++ {
+		if v[i] != other[i] {
+			if v[i] > other[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+type constraint struct {
+	op string
+	v  version
+}
+
+// parseConstraints 解析空格或者逗号分隔的版本约束，~ 和 ^ 展开为上下限
+func parseConstraints(s string) ([]constraint, error) {
+	var constraints []constraint
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		match := constraintRegexp.FindStringSubmatch(item)
+		if match == nil {
+			return nil, fmt.Errorf("invalid constraint %q", item)
+		}
+		v, parts, ok := parseVersionParts(match[2])
+		if !ok {
+			return nil, fmt.Errorf("invalid version %q", match[2])
+		}
+
+		switch op := match[1]; op {
+		case "~":
+			// ~1.2 -> >=1.2.0 <1.3.0, ~1 -> >=1.0.0 <2.0.0
+			upper := version{v[0] + 1}
+			if parts > 1 {
+				upper = version{v[0], v[1] + 1}
+			}
+			constraints = append(constraints, constraint{op: ">=", v: v}, constraint{op: "<", v: upper})
+		case "^":
+			// ^1.2.3 -> >=1.2.3 <2.0.0, ^0.2.3 -> >=0.2.3 <0.3.0
+			upper := version{v[0] + 1}
+			if v[0] == 0 {
+				upper = version{0, v[1] + 1}
+			}
+			constraints = append(constraints, constraint{op: ">=", v: v}, constraint{op: "<", v: upper})
+		case "":
+			constraints = append(constraints, constraint{op: "=", v: v})
+		default:
+			constraints = append(constraints, constraint{op: op, v: v})
+		}
+	}
+	if len(constraints) == 0 {
+		return nil, fmt.Errorf("empty version range")
+	}
+	return constraints, nil
+}
+
+func (c constraint) match(v version) bool {
+	r := v.compare(c.v)
+	switch c.op {
+	case ">=":
+		return r >= 0
+	case ">":
+		return r > 0
+	case "<=":
+		return r <= 0
+	case "<":
+		return r < 0
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	}
+	return false
+}
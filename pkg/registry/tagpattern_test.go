@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTagPattern(t *testing.T) {
+	tests := []struct {
+		image       string
+		wantPattern bool
+		wantErr     bool
+		wantName    string
+		wantKind    string
+		match       []string
+		notMatch    []string
+	}{
+		// 普通的镜像
+		{image: "nginx"},
+		{image: "nginx:1.25"},
+		{image: "library/nginx:1.25.3-alpine"},
+		{image: "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		{image: "localhost:5000/nginx"},
+		{image: "harbor.example.com:8443/library/nginx:1.25"},
+
+		{
+			image: "nginx:1.25.*", wantPattern: true, wantName: "nginx", wantKind: GlobTagPattern,
+			match: []string{"1.25.0", "1.25.3-alpine"}, notMatch: []string{"1.24.0", "1.25"},
+		},
+		{
+			image: "localhost:5000/library/nginx:1.2?", wantPattern: true, wantName: "localhost:5000/library/nginx", wantKind: GlobTagPattern,
+			match: []string{"1.25", "1.26"}, notMatch: []string{"1.2", "1.250"},
+		},
+		{
+			image: "redis:>=7.0 <8", wantPattern: true, wantName: "redis", wantKind: SemverTagPattern,
+			match: []string{"7.0", "7.2.4", "v7.4"}, notMatch: []string{"6.2.14", "8.0", "7.2-alpine", "latest"},
+		},
+		{
+			image: "harbor.example.com:8443/cache/redis:>=7.0,<8", wantPattern: true, wantName: "harbor.example.com:8443/cache/redis", wantKind: SemverTagPattern,
+			match: []string{"7.2.4"}, notMatch: []string{"8.0.0"},
+		},
+		{
+			image: "nginx:~1.25", wantPattern: true, wantName: "nginx", wantKind: SemverTagPattern,
+			match: []string{"1.25", "1.25.4"}, notMatch: []string{"1.24.9", "1.26.0"},
+		},
+		{
+			image: "nginx:~1", wantPattern: true, wantName: "nginx", wantKind: SemverTagPattern,
+			match: []string{"1.0", "1.27.1"}, notMatch: []string{"0.9", "2.0"},
+		},
+		{
+			image: "etcd:^3.5.1", wantPattern: true, wantName: "etcd", wantKind: SemverTagPattern,
+			match: []string{"3.5.1", "3.9.0"}, notMatch: []string{"3.5.0", "4.0.0"},
+		},
+		{
+			image: "coredns:^0.2.3", wantPattern: true, wantName: "coredns", wantKind: SemverTagPattern,
+			match: []string{"0.2.3", "0.2.9"}, notMatch: []string{"0.3.0", "1.0.0"},
+		},
+		{
+			image: "nginx:!=1.25.3", wantPattern: true, wantName: "nginx", wantKind: SemverTagPattern,
+			match: []string{"1.25.2"}, notMatch: []string{"1.25.3"},
+		},
+		{
+			image: `alpine:/^3\.1[89]/`, wantPattern: true, wantName: "alpine", wantKind: RegexTagPattern,
+			match: []string{"3.18", "3.19.1"}, notMatch: []string{"3.17", "edge"},
+		},
+		{
+			image: `localhost:5000/alpine:/^v\d+$/`, wantPattern: true, wantName: "localhost:5000/alpine", wantKind: RegexTagPattern,
+			match: []string{"v3"}, notMatch: []string{"3", "v3.1"},
+		},
+
+		// 非法的匹配规则
+		{image: "nginx:[1.25", wantPattern: true, wantErr: true},
+		{image: "redis:>=seven", wantPattern: true, wantErr: true},
+		{image: "alpine:/^3(/", wantPattern: true, wantErr: true},
+		{image: "nginx:1.25+", wantPattern: true, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.image, func(t *testing.T) {
+			p, ok, err := ParseTagPattern(tc.image)
+			if ok != tc.wantPattern {
+				t.Fatalf("ParseTagPattern() is pattern = %v, want %v", ok, tc.wantPattern)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseTagPattern() error = %v, want error %v", err, tc.wantErr)
+			}
+			if !ok || tc.wantErr {
+				return
+			}
+
+			if p.Name != tc.wantName || p.Kind != tc.wantKind {
+				t.Errorf("ParseTagPattern() = %s %s, want %s %s", p.Name, p.Kind, tc.wantName, tc.wantKind)
+			}
+			for _, tag := range tc.match {
+				if !p.Match(tag) {
+					t.Errorf("%s should match %s", tc.image, tag)
+				}
+			}
+			for _, tag := range tc.notMatch {
+				if p.Match(tag) {
+					t.Errorf("%s should not match %s", tc.image, tag)
+				}
+			}
+		})
+	}
+}
+
+func TestTagPatternSelect(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		tags  []string
+		max   int
+		want  []string
+	}{
+		{
+			name:  "semver newest first",
+			image: "redis:>=7.0 <8",
+			tags:  []string{"6.2", "7.0", "7.2.4", "latest", "7.2", "8.0", "7.2-alpine", "v7.1"},
+			want:  []string{"7.2.4", "7.2", "v7.1", "7.0"},
+		},
+		{
+			name:  "versions before other tags",
+			image: "nginx:1.25*",
+			tags:  []string{"1.25-alpine", "1.25.3", "1.25", "1.25.10", "1.25-perl"},
+			want:  []string{"1.25.10", "1.25.3", "1.25", "1.25-perl", "1.25-alpine"},
+		},
+		{
+			name:  "max limit",
+			image: "nginx:1.25.*",
+			tags:  []string{"1.25.0", "1.25.1", "1.25.2", "1.25.3"},
+			max:   2,
+			want:  []string{"1.25.3", "1.25.2"},
+		},
+		{
+			name:  "max larger than matched",
+			image: `alpine:/^3\./`,
+			tags:  []string{"3.18", "edge", "3.19"},
+			max:   10,
+			want:  []string{"3.19", "3.18"},
+		},
+		{
+			name:  "no limit",
+			image: "etcd:^3.5",
+			tags:  []string{"3.5.0", "3.5.9", "3.5.10", "3.6.0", "3.4.27"},
+			want:  []string{"3.6.0", "3.5.10", "3.5.9", "3.5.0"},
+		},
+		{
+			name:  "nothing matched",
+			image: "nginx:2.*",
+			tags:  []string{"1.25", "latest"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, ok, err := ParseTagPattern(tc.image)
+			if !ok || err != nil {
+				t.Fatalf("ParseTagPattern(%s) = %v, %v", tc.image, ok, err)
+			}
+			if got := p.Select(tc.tags, tc.max); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Select() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExpandTagPatterns(t *testing.T) {
+	f := newFakeRegistry(t)
+	for _, tag := range []string{"1.24.0", "1.25.0", "1.25.1", "1.25.2"} {
+		f.addImage("library/nginx", tag, Platform{OS: "linux", Architecture: "amd64"})
+	}
+
+	c := NewClient()
+	got, err := c.ExpandTagPatterns(context.Background(), []string{
+		f.host() + "/library/nginx:1.25.*",
+		"busybox:1.36",
+	}, 2)
+	if err != nil {
+		t.Fatalf("ExpandTagPatterns() error = %v", err)
+	}
+	want := []string{f.host() + "/library/nginx:1.25.2", f.host() + "/library/nginx:1.25.1", "busybox:1.36"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandTagPatterns() = %v, want %v", got, want)
+	}
+
+	_, err = c.ExpandTagPatterns(context.Background(), []string{f.host() + "/library/nginx:2.*"}, 0)
+	if err == nil || !strings.Contains(err.Error(), "no tags") {
+		t.Errorf("ExpandTagPatterns() error = %v, want no tags matched", err)
+	}
+}
@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 单页返回的 tag 数量，registry 可能返回更少
+const tagsPageSize = 1000
+
+// 防止异常的 registry 无限分页
+const maxTagsPages = 100
+
+// ListTags 列出仓库的所有 tag，按照 Link 头自动翻页
+func (c *Client) ListTags(ctx context.Context, host string, repository string) ([]string, error) {
+	var tags []string
+
+	u := fmt.Sprintf("%s/v2/%s/tags/list?n=%d", c.baseURL(host), repository, tagsPageSize)
+	for page := 0; len(u) != 0; page++ {
+		if page >= maxTagsPages {
+			return nil, fmt.Errorf("too many tag pages in %s/%s", host, repository)
+		}
+
+		resp, err := c.do(ctx, &request{
+			method: http.MethodGet,
+			host:   host,
+			url:    u,
+			scopes: []string{repositoryScope(repository, "pull")},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("failed to list tags of %s/%s: %w", host, repository, responseError(resp))
+			drainBody(resp)
+			return nil, err
+		}
+
+		var result struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(&limitedReader{r: resp.Body, n: maxManifestSize}).Decode(&result)
+		link := resp.Header.Get("Link")
+		drainBody(resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tags of %s/%s: %v", host, repository, err)
+		}
+		tags = append(tags, result.Tags...)
+
+		if u, err = c.nextPage(host, link); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextPage 解析 Link: </v2/<name>/tags/list?n=1000&last=x>; rel="next"，没有下一页时返回空
+func (c *Client) nextPage(host string, link string) (string, error) {
+	if len(link) == 0 || !strings.Contains(link, `rel="next"`) {
+		return "", nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("invalid link header %q from registry %s", link, host)
+	}
+	return c.resolveLocation(host, link[start+1:end])
+}
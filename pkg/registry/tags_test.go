@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTagsServer 按照 n 和 last 参数分页返回 tags，link 生成下一页的 Link 头
+func newTagsServer(t *testing.T, tags []string, pageSize int, link func(srv *httptest.Server, last string) string) (*httptest.Server, *[]string) {
+	var requests []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/nginx/tags/list" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		requests = append(requests, r.URL.RawQuery)
+
+		start := 0
+		if last := r.URL.Query().Get("last"); len(last) != 0 {
+			for i, tag := range tags {
+				if tag == last {
+					start = i + 1
+				}
+			}
+		}
+		if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n < pageSize {
+			pageSize = n
+		}
+		end := start + pageSize
+		if end > len(tags) {
+			end = len(tags)
+		}
+		page := tags[start:end]
+		if end < len(tags) {
+			w.Header().Set("Link", link(srv, page[len(page)-1]))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "library/nginx", "tags": page})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestListTagsPagination(t *testing.T) {
+	var tags []string
+	for i := 0; i < 7; i++ {
+		tags = append(tags, fmt.Sprintf("1.25.%d", i))
+	}
+
+	tests := []struct {
+		name      string
+		tags      []string
+		pageSize  int
+		link      func(srv *httptest.Server, last string) string
+		wantPages int
+	}{
+		{
+			name:      "single page",
+			tags:      tags,
+			pageSize:  10,
+			wantPages: 1,
+		},
+		{
+			name:     "relative link",
+			tags:     tags,
+			pageSize: 3,
+			link: func(srv *httptest.Server, last string) string {
+				return fmt.Sprintf(`</v2/library/nginx/tags/list?n=3&last=%s>; rel="next"`, last)
+			},
+			wantPages: 3,
+		},
+		{
+			name:     "absolute link",
+			tags:     tags,
+			pageSize: 2,
+			link: func(srv *httptest.Server, last string) string {
+				return fmt.Sprintf(`<%s/v2/library/nginx/tags/list?n=2&last=%s>; rel="next"`, srv.URL, last)
+			},
+			wantPages: 4,
+		},
+		{
+			name:      "empty repository",
+			pageSize:  10,
+			wantPages: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, requests := newTagsServer(t, tc.tags, tc.pageSize, tc.link)
+
+			got, err := NewClient().ListTags(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "library/nginx")
+			if err != nil {
+				t.Fatalf("ListTags() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.tags) {
+				t.Errorf("ListTags() = %v, want %v", got, tc.tags)
+			}
+			if len(*requests) != tc.wantPages {
+				t.Errorf("requested %d pages, want %d: %v", len(*requests), tc.wantPages, *requests)
+			}
+			if (*requests)[0] != fmt.Sprintf("n=%d", tagsPageSize) {
+				t.Errorf("first page query = %s", (*requests)[0])
+			}
+		})
+	}
+}
+
+func TestListTagsInvalidLink(t *testing.T) {
+	tests := []struct {
+		name string
+		link func(srv *httptest.Server, last string) string
+		want string
+	}{
+		{
+			name: "malformed link",
+			link: func(srv *httptest.Server, last string) string { return `/v2/library/nginx/tags/list; rel="next"` },
+			want: "invalid link header",
+		},
+		{
+			// 始终返回相同的下一页
+			name: "endless pages",
+			link: func(srv *httptest.Server, last string) string {
+				return `</v2/library/nginx/tags/list?n=1>; rel="next"`
+			},
+			want: "too many tag pages",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, _ := newTagsServer(t, []string{"1.25.0", "1.25.1"}, 1, tc.link)
+
+			_, err := NewClient().ListTags(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "library/nginx")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("ListTags() error = %v, want %s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestListTagsNotFound(t *testing.T) {
+	srv, _ := newTagsServer(t, nil, 1, nil)
+
+	_, err := NewClient().ListTags(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "library/missing")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("ListTags() error = %v, want 404", err)
+	}
+}
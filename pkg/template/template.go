@@ -1,5 +1,11 @@
 package template
 
+import (
+	"time"
+
+	"github.com/caoyingjunz/rainbow/pkg/registry"
+)
+
 type PluginTemplateConfig struct {
 	Default    DefaultOption    `yaml:"default"`
 	Kubernetes KubernetesOption `yaml:"kubernetes"`
@@ -9,8 +15,10 @@ type PluginTemplateConfig struct {
 }
 
 type DefaultOption struct {
-	PushKubernetes bool `yaml:"push_kubernetes"`
-	PushImages     bool `yaml:"push_images"`
+	PushKubernetes bool     `yaml:"push_kubernetes"`
+	PushImages     bool     `yaml:"push_images"`
+	Platforms      []string `yaml:"platforms"`
+	Force          bool     `yaml:"force"`
 }
 
 type KubernetesOption struct {
@@ -18,9 +26,21 @@ type KubernetesOption struct {
 }
 
 type PluginOption struct {
-	Callback string `yaml:"callback"`
-	TaskId   int64  `yaml:"task_id"`
-	Synced   bool   `yaml:"synced"`
+	Callback      string `yaml:"callback"`
+	CallbackToken string `yaml:"callback_token"`
+	TaskId        int64  `yaml:"task_id"`
+	Synced        bool   `yaml:"synced"`
+	Driver        string `yaml:"driver"`
+
+	Concurrency  int           `yaml:"concurrency"`
+	MaxRetries   int           `yaml:"max_retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	ImageTimeout time.Duration `yaml:"image_timeout"`
+
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+
+	Stages     []string `yaml:"stages"`
+	ImageHooks []string `yaml:"image_hooks"`
 }
 
 type Registry struct {
@@ -28,4 +48,8 @@ type Registry struct {
 	Namespace  string `yaml:"namespace"`
 	Username   string `yaml:"username"`
 	Password   string `yaml:"password"`
+	Token      string `yaml:"token"`
+	Insecure   bool   `yaml:"insecure"`
+
+	Naming registry.NamingPolicy `yaml:"naming"`
 }
@@ -1,5 +1,7 @@
 package types
 
+import "github.com/caoyingjunz/rainbow/pkg/registry"
+
 type (
 	CreateTaskRequest struct {
 		Name       string   `json:"name"`
@@ -7,6 +9,22 @@ type (
 		RegisterId int64    `json:"register_id"`
 		Images     []string `json:"images"`
 		AgentName  string   `json:"agent_name"`
+		// 未指定 AgentName 时，由调度器从匹配该标签选择器的 agent 中选择
+		AgentSelector string `json:"agent_selector"`
+		// 同步的镜像平台，例如 linux/amd64,linux/arm64，为空时同步所有平台
+		Platforms string `json:"platforms"`
+		// 为 true 时目标仓库已存在相同镜像也重新同步
+		Force bool `json:"force"`
+		// 为空时使用仓库的命名规则
+		Naming registry.NamingPolicy `json:"naming"`
+		// 从 manifest 和 chart 中提取的镜像会合并到 Images 中
+		Sources []ImageSource `json:"sources"`
+		// kubernetes 版本，例如 v1.23.6，展开为控制面组件的镜像
+		KubernetesVersions []string `json:"kubernetes_versions"`
+		// 附加组件，格式为 name 或者 name@version，例如 calico@v3.27.3
+		Addons []string `json:"addons"`
+		// 镜像中的 tag 匹配规则最多展开的 tag 数量，默认为 10，例如 nginx:1.25.*, redis:>=7.0 <8, alpine:/^3\.1[89]/
+		MaxTags int `json:"max_tags"`
 	}
 
 	UpdateTaskRequest struct {
@@ -18,6 +36,30 @@ type (
 		AgentName       string   `json:"agent_name"`
 		Status          string   `json:"status"`
 		Images          []string `json:"images"`
+		// 为空时保持任务当前的设置
+		Force *bool `json:"force"`
+		// 为空时保持任务当前的命名规则
+		Naming  registry.NamingPolicy `json:"naming"`
+		Sources []ImageSource         `json:"sources"`
+		// 追加的 kubernetes 版本和附加组件，已有的不会被删除
+		KubernetesVersions []string `json:"kubernetes_versions"`
+		Addons             []string `json:"addons"`
+		MaxTags            int      `json:"max_tags"`
+	}
+
+	// ImageSource 镜像来源，manifests 和 chart 至少指定一个
+	ImageSource struct {
+		// 多文档的 kubernetes yaml 或者 json
+		Manifests string `json:"manifests"`
+		// base64 编码的 chart .tgz 文件，需要 server 节点安装 helm
+		Chart string `json:"chart"`
+		// 渲染 chart 使用的 values.yaml 内容
+		Values string   `json:"values"`
+		Set    []string `json:"set"`
+	}
+
+	ExtractImagesRequest struct {
+		Sources []ImageSource `json:"sources" binding:"required"`
 	}
 
 	UpdateTaskStatusRequest struct {
@@ -27,21 +69,25 @@ type (
 	}
 
 	CreateRegistryRequest struct {
-		UserId     string `json:"user_id"`
-		Repository string `json:"repository"`
-		Namespace  string `json:"namespace"`
-		Username   string `json:"username"`
-		Password   string `json:"password"`
+		UserId     string                `json:"user_id"`
+		Repository string                `json:"repository"`
+		Namespace  string                `json:"namespace"`
+		Username   string                `json:"username"`
+		Password   string                `json:"password"`
+		Token      string                `json:"token"`
+		Naming     registry.NamingPolicy `json:"naming"`
 	}
 
 	UpdateRegistryRequest struct {
-		Id              int64  `json:"id"`
-		ResourceVersion int64  `json:"resource_version"`
-		UserId          string `json:"user_id"`
-		Repository      string `json:"repository"`
-		Namespace       string `json:"namespace"`
-		Username        string `json:"username"`
-		Password        string `json:"password"`
+		Id              int64                 `json:"id"`
+		ResourceVersion int64                 `json:"resource_version"`
+		UserId          string                `json:"user_id"`
+		Repository      string                `json:"repository"`
+		Namespace       string                `json:"namespace"`
+		Username        string                `json:"username"`
+		Password        string                `json:"password"`
+		Token           string                `json:"token"`
+		Naming          registry.NamingPolicy `json:"naming"`
 	}
 
 	CreateImageRequest struct {
@@ -61,9 +107,43 @@ type (
 	}
 
 	UpdateImageStatusRequest struct {
-		Name    string `json:"name"`
-		TaskId  int64  `json:"task_id"`
-		Status  string `json:"status"`
-		Message string `json:"message"`
+		Name         string `json:"name"`
+		TaskId       int64  `json:"task_id"`
+		Status       string `json:"status"`
+		Message      string `json:"message"`
+		SourceDigest string `json:"source_digest"`
+		TargetDigest string `json:"target_digest"`
+	}
+
+	UpdateImageProgressRequest struct {
+		Name       string `json:"name" binding:"required"`
+		TaskId     int64  `json:"task_id" binding:"required"`
+		Phase      string `json:"phase"`
+		BytesDone  int64  `json:"bytes_done"`
+		BytesTotal int64  `json:"bytes_total"`
+	}
+
+	RegisterAgentRequest struct {
+		Labels             map[string]string `json:"labels"`
+		Arch               string            `json:"arch"`
+		MaxConcurrentTasks int               `json:"max_concurrent_tasks"`
+	}
+
+	ClaimTaskRequest struct {
+		TaskId          int64 `json:"task_id"`
+		ResourceVersion int64 `json:"resource_version"`
+	}
+
+	// ReportTaskRequest agent 上报任务的分发结果，为空的字段不更新
+	ReportTaskRequest struct {
+		TaskId         int64  `json:"task_id"`
+		Status         string `json:"status"`
+		Message        string `json:"message"`
+		Dispatcher     string `json:"dispatcher"`
+		DispatchHandle string `json:"dispatch_handle"`
+		RunStatus      string `json:"run_status"`
+		RunLogs        string `json:"run_logs"`
+		// 为 true 时 agent 已处理取消请求
+		CancelHandled bool `json:"cancel_handled"`
 	}
 )
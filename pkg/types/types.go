@@ -16,3 +16,28 @@ type IdNameMeta struct {
 	ID   int64  `uri:"Id" binding:"required" form:"id"`
 	Name string `uri:"name" binding:"required" form:"name"`
 }
+
+// AgentNameMeta gin 要求同一位置的路由参数同名，因此 agent 名称复用 Id 参数
+type AgentNameMeta struct {
+	Name string `uri:"Id" binding:"required"`
+}
+
+type WatchMeta struct {
+	// 单位为秒
+	Timeout int `form:"timeout"`
+}
+
+type TaskIdMeta struct {
+	TaskId int64 `form:"task_id" binding:"required"`
+}
+
+// TaskRun 任务本次运行的状态和日志
+type TaskRun struct {
+	TaskId          int64  `json:"task_id"`
+	Status          string `json:"status"`
+	Dispatcher      string `json:"dispatcher"`
+	DispatchHandle  string `json:"dispatch_handle"`
+	RunStatus       string `json:"run_status"`
+	CancelRequested bool   `json:"cancel_requested"`
+	Logs            string `json:"logs"`
+}
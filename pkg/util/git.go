@@ -38,9 +38,17 @@ func (g *Git) Checkout() error {
 		return err
 	}
 
+	remoteBranches, err := g.RemoteBranches()
+	if err != nil {
+		return err
+	}
+
 	var cmd exec.Cmd
 	if InSlice(g.Branch, localBranches) {
 		cmd = g.executor.Command("git", "checkout", g.Branch)
+	} else if InSlice("origin/"+g.Branch, remoteBranches) {
+		// 任务重新执行时基于已推送的任务分支继续提交
+		cmd = g.executor.Command("git", "checkout", "remotes/origin/"+g.Branch, "-b", g.Branch)
 	} else {
 		cmd = g.executor.Command("git", "checkout", "remotes/origin/master", "-b", g.Branch)
 	}
@@ -84,7 +92,8 @@ func (g *Git) Add() error {
 }
 
 func (g *Git) Commit() error {
-	cmd := g.executor.Command("git", "commit", "-m", g.Title)
+	// 配置未变化时(例如任务重新执行)也需要提交以触发 CI
+	cmd := g.executor.Command("git", "commit", "--allow-empty", "-m", g.Title)
 	cmd.SetDir(g.RepoDir)
 
 	out, err := cmd.CombinedOutput()
@@ -116,10 +125,67 @@ func (g *Git) LocalBranches() ([]string, error) {
 
 	var branches []string
 	for _, b := range strings.Split(string(out), "\n") {
-		if len(b) == 0 {
+		b = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(b), "*"))
+		// 忽略游离状态，例如 (HEAD detached at origin/master)
+		if len(b) == 0 || strings.HasPrefix(b, "(") {
 			continue
 		}
-		branches = append(branches, strings.TrimSpace(b))
+		branches = append(branches, b)
 	}
 	return branches, nil
 }
+
+func (g *Git) RemoteBranches() ([]string, error) {
+	cmd := g.executor.Command("git", "branch", "-r")
+	cmd.SetDir(g.RepoDir)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v %s", err, string(out))
+	}
+
+	var branches []string
+	for _, b := range strings.Split(string(out), "\n") {
+		b = strings.TrimSpace(b)
+		// 忽略 origin/HEAD -> origin/master
+		if len(b) == 0 || strings.Contains(b, "->") {
+			continue
+		}
+		branches = append(branches, b)
+	}
+	return branches, nil
+}
+
+func (g *Git) Fetch() error {
+	cmd := g.executor.Command("git", "fetch", "origin")
+	cmd.SetDir(g.RepoDir)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v %s", err, string(out))
+	}
+	return nil
+}
+
+// Detach 切换到远端 master 的游离状态，以便删除本地分支
+func (g *Git) Detach() error {
+	cmd := g.executor.Command("git", "checkout", "--detach", "remotes/origin/master")
+	cmd.SetDir(g.RepoDir)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v %s", err, string(out))
+	}
+	return nil
+}
+
+func (g *Git) DeleteBranch(branch string) error {
+	cmd := g.executor.Command("git", "branch", "-D", branch)
+	cmd.SetDir(g.RepoDir)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v %s", err, string(out))
+	}
+	return nil
+}
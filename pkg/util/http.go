@@ -18,18 +18,31 @@ type HttpInterface interface {
 type httpClient struct {
 	timeout time.Duration
 	url     string
+	header  map[string]string
 }
 
 func NewHttpClient(timeout time.Duration, url string) *httpClient {
 	return &httpClient{timeout: timeout, url: url}
 }
 
+// NewHttpClientWithHeader 每个请求都会携带指定的 header，例如认证信息
+func NewHttpClientWithHeader(timeout time.Duration, url string, header map[string]string) *httpClient {
+	return &httpClient{timeout: timeout, url: url, header: header}
+}
+
+func (c *httpClient) setHeader(req *http.Request) {
+	for k, v := range c.header {
+		req.Header.Set(k, v)
+	}
+}
+
 func (c *httpClient) Get(url string, val interface{}) error {
 	client := &http.Client{Timeout: c.timeout}
 	req, err := http.NewRequest("", url, nil)
 	if err != nil {
 		return err
 	}
+	c.setHeader(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -63,6 +76,7 @@ func (c *httpClient) Post(url string, val interface{}, data map[string]interface
 	if err != nil {
 		return err
 	}
+	c.setHeader(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -96,6 +110,7 @@ func (c *httpClient) Put(url string, val interface{}, data map[string]interface{
 	if err != nil {
 		return err
 	}
+	c.setHeader(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return err